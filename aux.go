@@ -0,0 +1,169 @@
+package synth
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Aux is an aux bus on a Mixer. Channels send some of their signal to the bus, which is mixed together and goes
+// through the bus's effects before coming back into the mixer through its return channel. This lets lots of channels
+// share one reverb or delay, each with its own send level.
+type Aux struct {
+	input   *auxInput
+	channel *Channel
+}
+
+// auxInput is the input of an aux bus's effects, which holds the sum of the sends to the bus for the current sample.
+// It is only used by the goroutine streaming the mixer.
+type auxInput struct {
+	l, r float64
+}
+
+// clear clears the sum of the sends, ready for the next sample.
+func (a *auxInput) clear() {
+	a.l, a.r = 0, 0
+}
+
+// add adds a send to the sum.
+func (a *auxInput) add(l, r float64) {
+	a.l += l
+	a.r += r
+}
+
+// Stream returns the sum of the sends to the bus.
+func (a *auxInput) Stream(t float64) float64 {
+	return a.l
+}
+
+// StreamStereo returns the sum of the sends to the bus in stereo.
+func (a *auxInput) StreamStereo(t float64) (l, r float64) {
+	return a.l, a.r
+}
+
+// AddAux adds a new aux bus to the mixer, with a chain of effects. The effects should usually be fully wet, since the
+// dry signal is already heard through the channels sending to the bus.
+func (m *Mixer) AddAux(effects ...Effect) *Aux {
+	a := &Aux{input: &auxInput{}}
+	a.channel = m.add(&Channel{chain: NewChain(a.input, effects...), aux: a})
+
+	return a
+}
+
+// Effects returns the chain of effects on the bus.
+func (a *Aux) Effects() *Chain {
+	return a.channel.chain
+}
+
+// Return returns the channel which the bus comes back into the mixer through, which sets the level and pan of the
+// bus. Unlike other channels, it is still heard when other channels are soloed.
+func (a *Aux) Return() *Channel {
+	return a.channel
+}
+
+// Remove removes the bus from the mixer, along with every send to it.
+func (a *Aux) Remove() {
+	a.channel.Remove()
+}
+
+// send is a send from a channel to an aux bus. The level is a linear gain, and whether it is pre-fader is stored
+// atomically.
+type send struct {
+	aux   *Aux
+	level *Param
+	pre   uint32
+}
+
+// isPre returns true if the send is taken before the channel's gain and pan.
+func (s *send) isPre() bool {
+	return atomic.LoadUint32(&s.pre) == 1
+}
+
+// snapshotSends returns the current sends of the channel.
+func (c *Channel) snapshotSends() []*send {
+	return c.sends.Load().([]*send)
+}
+
+// findSend returns the send from the channel to an aux bus, or nil if there isn't one.
+func (c *Channel) findSend(aux *Aux) *send {
+	for _, s := range c.snapshotSends() {
+		if s.aux == aux {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// SetSend sets the level of the channel's send to an aux bus in decibels, adding the send if there isn't one already.
+// New sends are post-fader. The bus must belong to the same mixer as the channel, and aux returns can't send to other
+// buses so that buses can't feed back into each other; otherwise the send is ignored.
+func (c *Channel) SetSend(aux *Aux, db float64) {
+	c.mixer.m.Lock()
+	defer c.mixer.m.Unlock()
+
+	if c.aux != nil || aux.channel.mixer != c.mixer || aux.channel.isRemoved() {
+		return
+	}
+
+	s := c.findSend(aux)
+	if s == nil {
+		s = &send{aux: aux, level: NewParam(0)}
+
+		old := c.snapshotSends()
+		sends := make([]*send, len(old), len(old)+1)
+		copy(sends, old)
+		c.sends.Store(append(sends, s))
+	}
+
+	s.level.Set(DBToGain(db))
+}
+
+// Send returns the level of the channel's send to an aux bus in decibels, or negative infinity if there is no send.
+func (c *Channel) Send(aux *Aux) float64 {
+	s := c.findSend(aux)
+	if s == nil {
+		return math.Inf(-1)
+	}
+
+	return GainToDB(s.level.Target())
+}
+
+// SetSendPre sets whether the channel's send to an aux bus is pre-fader, meaning it is taken before the channel's
+// gain and pan so the bus keeps the same level when the channel is turned down. Sends are post-fader by default. A
+// muted channel sends nothing either way.
+func (c *Channel) SetSendPre(aux *Aux, pre bool) {
+	if s := c.findSend(aux); s != nil {
+		atomic.StoreUint32(&s.pre, boolToUint32(pre))
+	}
+}
+
+// SendPre returns true if the channel's send to an aux bus is pre-fader.
+func (c *Channel) SendPre(aux *Aux) bool {
+	s := c.findSend(aux)
+	return s != nil && s.isPre()
+}
+
+// RemoveSend removes the channel's send to an aux bus.
+func (c *Channel) RemoveSend(aux *Aux) {
+	c.mixer.m.Lock()
+	defer c.mixer.m.Unlock()
+
+	c.removeSend(aux)
+}
+
+// removeSend removes the channel's send to an aux bus. It must be called with the mixer's lock held.
+func (c *Channel) removeSend(aux *Aux) {
+	if c.findSend(aux) == nil {
+		return
+	}
+
+	old := c.snapshotSends()
+	sends := make([]*send, 0, len(old))
+	for _, s := range old {
+		if s.aux != aux {
+			sends = append(sends, s)
+		}
+	}
+
+	c.sends.Store(sends)
+}
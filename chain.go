@@ -0,0 +1,315 @@
+package synth
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChainFadeTime is how long a Chain takes to fade an effect in or out when it is added, removed, bypassed or moved,
+// in seconds. Fading stops the change from clicking.
+const ChainFadeTime = 0.01
+
+// Effect is a streamer which processes the sound from another streamer, its input. Every effect in the library, such
+// as Delay, Reverb and Compressor, is an Effect.
+type Effect interface {
+	StereoStreamer
+	SetInput(input Streamer)
+}
+
+// chainInput is the input of an effect in a chain. The chain sets the sample before it streams the effect, so the
+// chain's input is only streamed once however many effects there are.
+type chainInput struct {
+	l, r float64
+}
+
+// Stream returns the sample set by the chain.
+func (c *chainInput) Stream(t float64) float64 {
+	return c.l
+}
+
+// StreamStereo returns the samples set by the chain.
+func (c *chainInput) StreamStereo(t float64) (l, r float64) {
+	return c.l, c.r
+}
+
+// chainSlot is an effect in a chain. Whether it is bypassed, being removed or being moved is stored atomically, and
+// the rest of its state is only used by the goroutine streaming the chain.
+type chainSlot struct {
+	effect Effect
+	input  *chainInput
+
+	bypass   uint32
+	removing uint32
+	moving   uint32
+
+	// moveTo is where the slot is being moved to. It is only used with the chain's lock held.
+	moveTo int
+
+	// wet is how much of the effect is heard. It fades towards 0 when the effect is bypassed, removed or moved, and
+	// towards 1 otherwise.
+	wet float64
+}
+
+// faded returns true if the slot should be faded out.
+func (s *chainSlot) faded() bool {
+	return atomic.LoadUint32(&s.bypass) == 1 || s.isRemoving() || atomic.LoadUint32(&s.moving) == 1
+}
+
+// isRemoving returns true if the slot is being removed.
+func (s *chainSlot) isRemoving() bool {
+	return atomic.LoadUint32(&s.removing) == 1
+}
+
+// Chain is an ordered list of effects, each of which processes the output of the one before. Effects can be added,
+// removed, bypassed and moved while the chain is playing, and are faded in and out so that the changes don't click.
+// A chain is an Effect itself, so chains can be nested.
+//
+// Streaming a chain never waits for a lock: the list of effects is replaced as a whole whenever it changes and read
+// atomically. Effects which are removed or moved are faded out first, and taken out of the list once they have faded.
+//
+// A chain takes over the inputs of its effects, so an effect should only be in one chain at a time.
+type Chain struct {
+	Input Streamer
+
+	// m is only held by goroutines changing the list of effects, so that changes made at the same time aren't lost.
+	m     *sync.Mutex
+	slots atomic.Value
+}
+
+// NewChain returns a new chain of effects around a streamer.
+func NewChain(input Streamer, effects ...Effect) *Chain {
+	c := &Chain{
+		Input: input,
+		m:     &sync.Mutex{},
+	}
+
+	slots := make([]*chainSlot, 0, len(effects))
+	for _, effect := range effects {
+		slots = append(slots, c.newSlot(effect, 1))
+	}
+
+	c.slots.Store(slots)
+
+	return c
+}
+
+// newSlot returns a new slot for an effect, connecting the effect to the chain.
+func (c *Chain) newSlot(effect Effect, wet float64) *chainSlot {
+	slot := &chainSlot{effect: effect, input: &chainInput{}, wet: wet}
+	effect.SetInput(slot.input)
+
+	return slot
+}
+
+// snapshot returns the current slots of the chain.
+func (c *Chain) snapshot() []*chainSlot {
+	return c.slots.Load().([]*chainSlot)
+}
+
+// SetInput sets the streamer which the chain processes.
+func (c *Chain) SetInput(input Streamer) {
+	c.Input = input
+}
+
+// Stream returns the processed sample for a given point in time `t`.
+func (c *Chain) Stream(t float64) float64 {
+	x := c.Input.Stream(t)
+
+	l, _ := c.process(t, x, x, false)
+	return l
+}
+
+// StreamStereo returns the processed left and right channels for a given point in time `t`.
+func (c *Chain) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(c.Input, t)
+
+	return c.process(t, l, r, true)
+}
+
+// process runs a pair of samples through each effect in turn.
+func (c *Chain) process(t, l, r float64, stereo bool) (float64, float64) {
+	step := 1 / (ChainFadeTime * float64(sr))
+
+	for _, slot := range c.snapshot() {
+		target := 1.0
+		if slot.faded() {
+			target = 0
+		}
+
+		if slot.wet < target {
+			slot.wet = math.Min(target, slot.wet+step)
+		} else if slot.wet > target {
+			slot.wet = math.Max(target, slot.wet-step)
+		}
+
+		// Effects which have faded out completely are skipped, so bypassed effects cost nothing.
+		if slot.wet == 0 && target == 0 {
+			continue
+		}
+
+		slot.input.l, slot.input.r = l, r
+
+		var el, er float64
+		if stereo {
+			el, er = slot.effect.StreamStereo(t)
+		} else {
+			el = slot.effect.Stream(t)
+			er = el
+		}
+
+		l += (el - l) * slot.wet
+		r += (er - r) * slot.wet
+	}
+
+	return l, r
+}
+
+// afterFade calls a function with the lock held once an effect has had time to fade out.
+func (c *Chain) afterFade(f func()) {
+	time.AfterFunc(time.Duration((ChainFadeTime+removeMargin)*float64(time.Second)), func() {
+		c.m.Lock()
+		f()
+		c.m.Unlock()
+	})
+}
+
+// without returns the slots of the chain without one of them. It must be called with the lock held.
+func (c *Chain) without(slot *chainSlot) []*chainSlot {
+	old := c.snapshot()
+
+	slots := make([]*chainSlot, 0, len(old))
+	for _, other := range old {
+		if other != slot {
+			slots = append(slots, other)
+		}
+	}
+
+	return slots
+}
+
+// insertSlot inserts a slot at an index, limited to the length of the chain. The slots are copied rather than changed
+// in place, so that a snapshot being streamed isn't changed. It must be called with the lock held.
+func (c *Chain) insertSlot(i int, slot *chainSlot) {
+	c.insertInto(c.snapshot(), i, slot)
+}
+
+// insertInto stores a copy of a list of slots with a slot inserted at an index, limited to the length of the list. It
+// must be called with the lock held.
+func (c *Chain) insertInto(old []*chainSlot, i int, slot *chainSlot) {
+	if i < 0 {
+		i = 0
+	}
+
+	if i > len(old) {
+		i = len(old)
+	}
+
+	slots := make([]*chainSlot, 0, len(old)+1)
+	slots = append(slots, old[:i]...)
+	slots = append(slots, slot)
+	slots = append(slots, old[i:]...)
+
+	c.slots.Store(slots)
+}
+
+// find returns the slot of an effect which isn't being removed.
+func (c *Chain) find(effect Effect) *chainSlot {
+	for _, slot := range c.snapshot() {
+		if slot.effect == effect && !slot.isRemoving() {
+			return slot
+		}
+	}
+
+	return nil
+}
+
+// Add adds an effect to the end of the chain. It fades in.
+func (c *Chain) Add(effect Effect) {
+	c.m.Lock()
+	c.insertSlot(len(c.snapshot()), c.newSlot(effect, 0))
+	c.m.Unlock()
+}
+
+// Insert adds an effect at a position in the chain, where 0 is the first effect. It fades in.
+func (c *Chain) Insert(i int, effect Effect) {
+	c.m.Lock()
+	c.insertSlot(i, c.newSlot(effect, 0))
+	c.m.Unlock()
+}
+
+// Remove fades an effect out and then removes it from the chain. It returns false if the effect isn't in the chain.
+func (c *Chain) Remove(effect Effect) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	slot := c.find(effect)
+	if slot == nil {
+		return false
+	}
+
+	atomic.StoreUint32(&slot.removing, 1)
+	c.afterFade(func() {
+		c.slots.Store(c.without(slot))
+	})
+
+	return true
+}
+
+// Move fades an effect out, moves it to a new position in the chain and then fades it back in. It returns false if
+// the effect isn't in the chain.
+func (c *Chain) Move(effect Effect, to int) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	slot := c.find(effect)
+	if slot == nil {
+		return false
+	}
+
+	slot.moveTo = to
+
+	// If the effect is already being moved, it goes to the new position when the earlier move finishes.
+	if atomic.SwapUint32(&slot.moving, 1) == 1 {
+		return true
+	}
+
+	c.afterFade(func() {
+		atomic.StoreUint32(&slot.moving, 0)
+
+		if !slot.isRemoving() {
+			c.insertInto(c.without(slot), slot.moveTo, slot)
+		}
+	})
+
+	return true
+}
+
+// SetBypass sets whether an effect is bypassed. A bypassed effect stays in the chain but isn't heard, and isn't
+// streamed once it has faded out.
+func (c *Chain) SetBypass(effect Effect, bypass bool) {
+	if slot := c.find(effect); slot != nil {
+		atomic.StoreUint32(&slot.bypass, boolToUint32(bypass))
+	}
+}
+
+// Bypassed returns true if an effect is bypassed.
+func (c *Chain) Bypassed(effect Effect) bool {
+	slot := c.find(effect)
+	return slot != nil && atomic.LoadUint32(&slot.bypass) == 1
+}
+
+// Effects returns the effects in the chain, in order.
+func (c *Chain) Effects() []Effect {
+	slots := c.snapshot()
+
+	effects := make([]Effect, 0, len(slots))
+	for _, slot := range slots {
+		if !slot.isRemoving() {
+			effects = append(effects, slot.effect)
+		}
+	}
+
+	return effects
+}
@@ -1,38 +1,99 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/ollybritton/synth"
 	"github.com/ollybritton/synth/instruments"
 )
 
 func main() {
+	name := flag.String("instrument", "bell", "name of the instrument to play")
+	patch := flag.String("patch", "", "path to a patch file to load and play")
+	list := flag.Bool("list", false, "list the available instruments")
+	flag.Parse()
+
+	if *patch != "" {
+		loaded, err := instruments.RegisterPatchFile(*patch)
+		if err != nil {
+			log.Fatalf("Could not load patch: %v", err)
+		}
+
+		*name = loaded
+	}
+
+	if *list {
+		for _, info := range instruments.List() {
+			fmt.Printf("%-12s %-10s %s\n", info.Name, info.Category, info.Description)
+		}
+
+		return
+	}
+
 	// env := synth.NewADSREnvelope(0.2, 0.8, 0.05, 0.03, 0.05)
 	// env := synth.NewASREnvelope(0.2, 0.05, 0.6)
 
-	i := instruments.Bell()
-	i.SetAmp(0.05)
-	s := synth.NewPolySynth(i)
+	s, err := instruments.NewPoly(*name)
+	if err != nil {
+		log.Fatalf("Could not create instrument: %v", err)
+	}
+	s.SetAmp(0.05)
 
 	synth.GlobalMixer.Add(s)
 
+	go warnClipping()
+
 	in := OpenMidiInput()
 	defer in.Close()
 
 	for event := range in.Listen() {
-		switch event.Status {
-		case 144:
-			fmt.Println("")
-			fmt.Println("midi on", event.Data1)
-			s.TriggerAttack([]float64{midiToFreq(event.Data1)})
-
-		case 128:
-			fmt.Println("midi off", event.Data1)
-			s.TriggerRelease([]float64{midiToFreq(event.Data1)})
+		channel := int(event.Status & 0x0F)
+		key := int(event.Data1)
+
+		switch event.Status & 0xF0 {
+		case 0x90:
+			// A note on message with a velocity of 0 is equivalent to a note off message.
+			if event.Data2 == 0 {
+				s.NoteOff(channel, key)
+				continue
+			}
+
+			s.NoteOn(channel, key, synth.MIDIVelocity(int(event.Data2)))
+
+		case 0x80:
+			s.NoteOff(channel, key)
+
+		case 0xB0:
+			// Controller 64 is the sustain pedal.
+			if event.Data1 == 64 {
+				s.SetSustain(event.Data2 >= 64)
+				continue
+			}
+
+			s.Controls().Set(int(event.Data1), float64(event.Data2)/127)
+
+		case 0xD0:
+			s.Controls().SetAftertouch(float64(event.Data1) / 127)
 		}
 	}
 
 	select {}
 
 }
+
+// warnClipping logs how many samples of the output have clipped every second, if any have.
+func warnClipping() {
+	reported := uint64(0)
+
+	for range time.Tick(time.Second) {
+		clips := synth.ClipCount()
+		if clips > reported {
+			log.Printf("output clipped %d times, turn the mix down", clips-reported)
+		}
+
+		reported = clips
+	}
+}
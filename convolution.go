@@ -0,0 +1,253 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ConvolutionBlockSize is the number of samples processed at a time by a ConvolutionReverb. The reverb is delayed by
+// this many samples, which is about 6ms.
+const ConvolutionBlockSize = 256
+
+// convolver convolves one channel with an impulse response using uniformly partitioned overlap-save convolution. The
+// impulse response is split into blocks, each of which is convolved with the input in the frequency domain, so the
+// latency is only one block however long the impulse response is.
+type convolver struct {
+	fft  *fft
+	size int
+
+	// partitions are the transforms of each block of the impulse response.
+	partitions [][]complex128
+	// history holds the transforms of the most recent blocks of input, one for each partition.
+	history [][]complex128
+	current int
+
+	input  []float64
+	output []float64
+	pos    int
+
+	scratch []complex128
+	acc     []complex128
+}
+
+// newConvolver returns a convolver for an impulse response, processing `size` samples at a time.
+func newConvolver(ir []float64, size int) *convolver {
+	c := &convolver{
+		fft:  newFFT(2 * size),
+		size: size,
+
+		input:   make([]float64, 2*size),
+		output:  make([]float64, size),
+		scratch: make([]complex128, 2*size),
+		acc:     make([]complex128, 2*size),
+	}
+
+	for start := 0; start < len(ir); start += size {
+		partition := make([]complex128, 2*size)
+		for i := 0; i < size && start+i < len(ir); i++ {
+			partition[i] = complex(ir[start+i], 0)
+		}
+
+		c.fft.transform(partition, false)
+
+		c.partitions = append(c.partitions, partition)
+		c.history = append(c.history, make([]complex128, 2*size))
+	}
+
+	return c
+}
+
+// process convolves a single sample. The output is delayed by the block size.
+func (c *convolver) process(x float64) float64 {
+	y := c.output[c.pos]
+
+	c.input[c.size+c.pos] = x
+	c.pos++
+
+	if c.pos == c.size {
+		c.processBlock()
+		c.pos = 0
+	}
+
+	return y
+}
+
+// processBlock convolves the last block of input with the whole impulse response.
+func (c *convolver) processBlock() {
+	if len(c.partitions) == 0 {
+		return
+	}
+
+	// The transform covers the previous block as well as the current one, and only the second half of the result is
+	// kept. The first half is corrupted by the circular wrap-around of the FFT.
+	spectrum := c.history[c.current]
+	for i, x := range c.input {
+		spectrum[i] = complex(x, 0)
+	}
+	c.fft.transform(spectrum, false)
+
+	for i := range c.acc {
+		c.acc[i] = 0
+	}
+
+	// Each partition of the impulse response is applied to the input from that many blocks ago.
+	for k, partition := range c.partitions {
+		past := c.history[(c.current-k+len(c.history))%len(c.history)]
+		for i := range c.acc {
+			c.acc[i] += past[i] * partition[i]
+		}
+	}
+
+	copy(c.scratch, c.acc)
+	c.fft.transform(c.scratch, true)
+
+	for i := range c.output {
+		c.output[i] = real(c.scratch[c.size+i])
+	}
+
+	copy(c.input[:c.size], c.input[c.size:])
+	c.current = (c.current + 1) % len(c.history)
+}
+
+// reset clears the convolver's memory of previous input.
+func (c *convolver) reset() {
+	for _, spectrum := range c.history {
+		for i := range spectrum {
+			spectrum[i] = 0
+		}
+	}
+
+	for i := range c.input {
+		c.input[i] = 0
+	}
+
+	for i := range c.output {
+		c.output[i] = 0
+	}
+
+	c.pos = 0
+}
+
+// ConvolutionReverb is a reverb which convolves its input with a recording of a real space or speaker cabinet,
+// called an impulse response. A stereo impulse response uses its left channel for the left output and its right
+// channel for the right output, a mono one is used for both.
+//
+// The reverb is delayed by ConvolutionBlockSize samples. It keeps track of the samples it has produced, so each
+// sample should only be streamed once, using either Stream or StreamStereo.
+type ConvolutionReverb struct {
+	Input Streamer
+
+	m   *sync.Mutex
+	mix *Param
+
+	channels [2]*convolver
+}
+
+// NewConvolutionReverb returns a new convolution reverb around a streamer using an impulse response. The impulse
+// response is resampled to the output sample rate and normalised so that the reverb is roughly as loud as its
+// input. The mix is how much of the reverb is heard, from 0 to 1.
+func NewConvolutionReverb(input Streamer, ir *Sample, mix float64) (*ConvolutionReverb, error) {
+	if ir.Len() == 0 {
+		return nil, fmt.Errorf("impulse response is empty")
+	}
+
+	ir = ir.Resample(int(sr))
+
+	// Every channel is scaled by the same amount so that the balance between them is kept.
+	energy := 0.0
+	for _, channel := range ir.Channels {
+		for _, x := range channel {
+			energy += x * x
+		}
+	}
+	energy /= float64(len(ir.Channels))
+
+	gain := 1.0
+	if energy > 0 {
+		gain = 1 / math.Sqrt(energy)
+	}
+
+	r := &ConvolutionReverb{
+		Input: input,
+		m:     &sync.Mutex{},
+		mix:   NewParam(mix),
+	}
+
+	for c := range r.channels {
+		channel := ir.Channels[0]
+		if c < len(ir.Channels) {
+			channel = ir.Channels[c]
+		}
+
+		scaled := make([]float64, len(channel))
+		for i, x := range channel {
+			scaled[i] = x * gain
+		}
+
+		r.channels[c] = newConvolver(scaled, ConvolutionBlockSize)
+	}
+
+	return r, nil
+}
+
+// LoadConvolutionReverb returns a new convolution reverb around a streamer using an impulse response loaded from a
+// WAV file.
+func LoadConvolutionReverb(input Streamer, path string, mix float64) (*ConvolutionReverb, error) {
+	ir, err := LoadWAV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConvolutionReverb(input, ir, mix)
+}
+
+// SetInput sets the streamer which the reverb processes.
+func (r *ConvolutionReverb) SetInput(input Streamer) {
+	r.Input = input
+}
+
+// Stream returns the reverberated sample for a given point in time `t`.
+func (r *ConvolutionReverb) Stream(t float64) float64 {
+	x := r.Input.Stream(t)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	mix := r.mix.At(t)
+	return x*(1-mix) + r.channels[0].process(x)*mix
+}
+
+// StreamStereo returns the reverberated left and right channels for a given point in time `t`.
+func (r *ConvolutionReverb) StreamStereo(t float64) (float64, float64) {
+	l, rr := StreamStereo(r.Input, t)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	mix := r.mix.At(t)
+	wetL := r.channels[0].process(l)
+	wetR := r.channels[1].process(rr)
+
+	return l*(1-mix) + wetL*mix, rr*(1-mix) + wetR*mix
+}
+
+// SetMix sets how much of the reverb is heard, where 0 is only the input and 1 is only the reverb.
+func (r *ConvolutionReverb) SetMix(mix float64) {
+	r.mix.Set(mix)
+}
+
+// Latency returns how long the reverb is delayed by, in seconds.
+func (r *ConvolutionReverb) Latency() float64 {
+	return float64(ConvolutionBlockSize) / float64(sr)
+}
+
+// Reset clears the reverb's tail.
+func (r *ConvolutionReverb) Reset() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, c := range r.channels {
+		c.reset()
+	}
+}
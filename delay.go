@@ -0,0 +1,270 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// MaxDelayTime is the longest delay time in seconds supported by a Delay.
+const MaxDelayTime = 4.0
+
+// delayTimeSmoothing is roughly how long it takes a delay to glide to a new delay time, in seconds. Changing the time
+// smoothly bends the pitch of the echoes like a tape delay instead of clicking.
+const delayTimeSmoothing = 0.05
+
+// delayLine is a circular buffer of past samples which can be read at fractional delays.
+type delayLine struct {
+	buf []float64
+	pos int
+}
+
+// newDelayLine returns a delay line which can delay by up to `length` samples.
+func newDelayLine(length int) *delayLine {
+	return &delayLine{buf: make([]float64, length+2)}
+}
+
+// read returns the sample from `delay` samples ago, interpolating between samples.
+func (d *delayLine) read(delay float64) float64 {
+	delay = math.Max(1, math.Min(float64(len(d.buf)-2), delay))
+
+	i := int(delay)
+	frac := delay - float64(i)
+
+	// The newest sample was written one sample ago.
+	a := d.buf[(d.pos-i+1+len(d.buf))%len(d.buf)]
+	b := d.buf[(d.pos-i+len(d.buf))%len(d.buf)]
+
+	return a + (b-a)*frac
+}
+
+// write adds a new sample to the delay line.
+func (d *delayLine) write(x float64) {
+	d.pos = (d.pos + 1) % len(d.buf)
+	d.buf[d.pos] = x
+}
+
+// reset clears the delay line.
+func (d *delayLine) reset() {
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+}
+
+// Delay is an effect which repeats its input after a delay, feeding the echoes back into themselves so that they die
+// away gradually. Like any effect, it can be added to the insert chain of a mixer input, or to the mixer's output:
+//
+//	synth.GlobalMixer.Output().Add(synth.NewDelay(nil, 375, 0.4, 0.3))
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Delay struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	time     float64
+	sync     Division
+	tempo    *Tempo
+	feedback *Param
+	mix      *Param
+	pingPong bool
+
+	lowCut, highCut float64
+
+	left, right       *delayLine
+	filters           [2][2]*Biquad
+	delay             float64
+	started           bool
+	smoothing, maxLen float64
+}
+
+// NewDelay returns a new delay around a streamer. The delay time is in milliseconds, the feedback is how much of
+// each echo is fed back into the delay, and the mix is how much of the delayed signal is heard, from 0 to 1.
+func NewDelay(input Streamer, ms, feedback, mix float64) *Delay {
+	length := int(MaxDelayTime * float64(sr))
+
+	d := &Delay{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		time:     ms,
+		tempo:    GlobalTempo,
+		feedback: NewParam(clampFeedback(feedback)),
+		mix:      NewParam(mix),
+
+		left:  newDelayLine(length),
+		right: newDelayLine(length),
+
+		smoothing: 1 - math.Exp(-1/(delayTimeSmoothing*float64(sr))),
+		maxLen:    float64(length),
+	}
+
+	for i := range d.filters {
+		d.filters[i] = [2]*Biquad{NewBiquad(HighPass, 20, math.Sqrt2/2), NewBiquad(LowPass, 20000, math.Sqrt2/2)}
+	}
+
+	return d
+}
+
+// NewSyncedDelay returns a new delay whose delay time is a note length at the global tempo.
+func NewSyncedDelay(input Streamer, div Division, feedback, mix float64) *Delay {
+	d := NewDelay(input, 0, feedback, mix)
+	d.sync = div
+
+	return d
+}
+
+// SetInput sets the streamer which the delay processes.
+func (d *Delay) SetInput(input Streamer) {
+	d.Input = input
+}
+
+// Stream returns the delayed sample for a given point in time `t`.
+func (d *Delay) Stream(t float64) float64 {
+	x := d.Input.Stream(t)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	feedback, mix := d.feedback.At(t), d.mix.At(t)
+
+	delay := d.delaySamples()
+	wet := d.left.read(delay)
+	d.left.write(x + feedback*d.filter(0, wet))
+
+	return x*(1-mix) + wet*mix
+}
+
+// StreamStereo returns the delayed left and right channels for a given point in time `t`. In ping-pong mode, the
+// echoes bounce between the left and right channels.
+func (d *Delay) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(d.Input, t)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	feedback, mix := d.feedback.At(t), d.mix.At(t)
+
+	delay := d.delaySamples()
+	wetL := d.left.read(delay)
+	wetR := d.right.read(delay)
+
+	if d.pingPong {
+		d.left.write((l+r)/2 + feedback*d.filter(1, wetR))
+		d.right.write(feedback * d.filter(0, wetL))
+	} else {
+		d.left.write(l + feedback*d.filter(0, wetL))
+		d.right.write(r + feedback*d.filter(1, wetR))
+	}
+
+	return l*(1-mix) + wetL*mix, r*(1-mix) + wetR*mix
+}
+
+// delaySamples returns the current delay time in samples, moving it smoothly towards the target. It must be called
+// with the lock held.
+func (d *Delay) delaySamples() float64 {
+	seconds := d.time / 1000
+	if d.sync != 0 {
+		seconds = d.tempo.Seconds(d.sync)
+	}
+
+	target := math.Max(1, math.Min(d.maxLen, seconds*float64(sr)))
+
+	if !d.started {
+		d.delay = target
+		d.started = true
+	}
+
+	d.delay += (target - d.delay) * d.smoothing
+	return d.delay
+}
+
+// filter filters the echoes of a channel before they are fed back. It must be called with the lock held.
+func (d *Delay) filter(channel int, x float64) float64 {
+	if d.lowCut > 0 {
+		x = d.filters[channel][0].Process(x)
+	}
+
+	if d.highCut > 0 {
+		x = d.filters[channel][1].Process(x)
+	}
+
+	return x
+}
+
+// SetTime sets the delay time in milliseconds. This stops the delay following the tempo.
+func (d *Delay) SetTime(ms float64) {
+	d.m.Lock()
+	d.time = ms
+	d.sync = 0
+	d.m.Unlock()
+}
+
+// SetSync makes the delay time follow a tempo. If the tempo is nil, the global tempo is used.
+func (d *Delay) SetSync(div Division, tempo *Tempo) {
+	if tempo == nil {
+		tempo = GlobalTempo
+	}
+
+	d.m.Lock()
+	d.sync = div
+	d.tempo = tempo
+	d.m.Unlock()
+}
+
+// SetFeedback sets how much of each echo is fed back into the delay. Values of 1 or more make the echoes build up
+// forever, so they are limited to just below 1.
+func (d *Delay) SetFeedback(feedback float64) {
+	d.feedback.Set(clampFeedback(feedback))
+}
+
+// SetMix sets how much of the delayed signal is heard, where 0 is only the input and 1 is only the echoes.
+func (d *Delay) SetMix(mix float64) {
+	d.mix.Set(mix)
+}
+
+// SetPingPong sets whether the echoes bounce between the left and right channels.
+func (d *Delay) SetPingPong(on bool) {
+	d.m.Lock()
+	d.pingPong = on
+	d.m.Unlock()
+}
+
+// SetFilter filters the echoes each time they are fed back, so that they get darker or thinner as they die away.
+// The low cut removes frequencies below it and the high cut removes frequencies above it, in hertz. A value of 0
+// turns that part of the filter off.
+func (d *Delay) SetFilter(lowCut, highCut float64) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.lowCut, d.highCut = lowCut, highCut
+
+	for _, filters := range d.filters {
+		if lowCut > 0 {
+			filters[0].SetCutoff(lowCut)
+		}
+
+		if highCut > 0 {
+			filters[1].SetCutoff(highCut)
+		}
+	}
+}
+
+// Reset clears the echoes stored in the delay.
+func (d *Delay) Reset() {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.left.reset()
+	d.right.reset()
+
+	for _, filters := range d.filters {
+		filters[0].Reset()
+		filters[1].Reset()
+	}
+}
+
+// clampFeedback limits the feedback of a delay to just below 1 so that it can't build up forever.
+func clampFeedback(feedback float64) float64 {
+	return math.Max(-0.99, math.Min(0.99, feedback))
+}
@@ -0,0 +1,344 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// ShapeFunc is a waveshaper's transfer curve, which maps each input sample to an output sample. Curves should map
+// the range -1 to 1 into the range -1 to 1.
+type ShapeFunc func(x float64) float64
+
+// SoftClip rounds off peaks smoothly, like an overdriven amplifier.
+func SoftClip(x float64) float64 {
+	return math.Tanh(x)
+}
+
+// HardClip cuts off anything outside the range -1 to 1, which sounds harsh and buzzy.
+func HardClip(x float64) float64 {
+	return math.Max(-1, math.Min(1, x))
+}
+
+// Foldback folds anything outside the range -1 to 1 back into it, which adds lots of bright harmonics as the drive is
+// turned up.
+func Foldback(x float64) float64 {
+	// This is a triangle wave of the input, so it folds any number of times.
+	return 1 - math.Abs(math.Mod(math.Mod(x+1, 4)+4, 4)-2)
+}
+
+// Tube is an asymmetric curve which clips positive peaks more gently than negative ones, like a valve amplifier. The
+// asymmetry adds even harmonics, which sound warmer.
+func Tube(x float64) float64 {
+	if x >= 0 {
+		return math.Tanh(x)
+	}
+
+	return math.Tanh(1.5*x) / 1.5 * (1 + 0.5*math.Tanh(-x))
+}
+
+// TransferCurve returns a custom transfer curve from a list of output values spread evenly over the input range -1 to
+// 1. Values in between are interpolated, and inputs outside the range use the value at the end.
+func TransferCurve(points ...float64) ShapeFunc {
+	points = append([]float64(nil), points...)
+
+	return func(x float64) float64 {
+		if len(points) == 0 {
+			return x
+		}
+
+		if len(points) == 1 {
+			return points[0]
+		}
+
+		pos := (math.Max(-1, math.Min(1, x)) + 1) / 2 * float64(len(points)-1)
+		i := int(pos)
+		if i >= len(points)-1 {
+			return points[len(points)-1]
+		}
+
+		frac := pos - float64(i)
+		return points[i] + (points[i+1]-points[i])*frac
+	}
+}
+
+// oversampler runs a function at a multiple of the sample rate, filtering before and after so that the harmonics it
+// adds above the original Nyquist frequency don't alias back down as inharmonic noise.
+type oversampler struct {
+	factor int
+	up     [2]*Biquad
+	down   [2]*Biquad
+}
+
+// newOversampler returns an oversampler which runs at `factor` times the sample rate.
+func newOversampler(factor int) *oversampler {
+	o := &oversampler{factor: factor}
+
+	// Each side is a fourth-order Butterworth filter just below the original Nyquist frequency. The biquads think
+	// they are running at the normal sample rate, so the cutoff is divided by the factor.
+	cutoff := 0.45 * float64(sr) / float64(factor)
+	for i, q := range []float64{0.5412, 1.3066} {
+		o.up[i] = NewBiquad(LowPass, cutoff, q)
+		o.down[i] = NewBiquad(LowPass, cutoff, q)
+	}
+
+	return o
+}
+
+// process runs a single sample through `f` at the higher sample rate.
+func (o *oversampler) process(x float64, f func(float64) float64) float64 {
+	if o.factor <= 1 {
+		return f(x)
+	}
+
+	var y float64
+	for i := 0; i < o.factor; i++ {
+		// The new samples in between are zeros, and the filter smooths them into a curve. The gain makes up for
+		// the energy spread across them.
+		in := 0.0
+		if i == 0 {
+			in = x * float64(o.factor)
+		}
+
+		in = o.up[1].Process(o.up[0].Process(in))
+		y = o.down[1].Process(o.down[0].Process(f(in)))
+	}
+
+	return y
+}
+
+// Distortion is an effect which drives its input through a waveshaper. It can run the waveshaper at 2 or 4 times the
+// sample rate to reduce aliasing.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Distortion struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	shape  ShapeFunc
+	drive  *Param
+	output *Param
+	mix    *Param
+
+	oversamplers [2]*oversampler
+	// The previous input and output of the filter which removes any DC offset added by asymmetric curves.
+	dcX, dcY [2]float64
+}
+
+// NewDistortion returns a new distortion around a streamer. The drive is how much the input is amplified before it is
+// shaped, and the mix is how much of the effect is heard, from 0 to 1.
+func NewDistortion(input Streamer, shape ShapeFunc, drive, mix float64) *Distortion {
+	d := &Distortion{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		shape:  shape,
+		drive:  NewParam(drive),
+		output: NewParam(1),
+		mix:    NewParam(mix),
+	}
+
+	d.setOversampling(1)
+
+	return d
+}
+
+// SetInput sets the streamer which the distortion processes.
+func (d *Distortion) SetInput(input Streamer) {
+	d.Input = input
+}
+
+// Stream returns the distorted sample for a given point in time `t`.
+func (d *Distortion) Stream(t float64) float64 {
+	x := d.Input.Stream(t)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.process(0, x, t)
+}
+
+// StreamStereo returns the distorted left and right channels for a given point in time `t`.
+func (d *Distortion) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(d.Input, t)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.process(0, l, t), d.process(1, r, t)
+}
+
+// process distorts a sample from one channel at time `t`. It must be called with the lock held.
+func (d *Distortion) process(channel int, x, t float64) float64 {
+	drive, output, mix := d.drive.At(t), d.output.At(t), d.mix.At(t)
+
+	wet := d.oversamplers[channel].process(x, func(x float64) float64 {
+		return d.shape(x * drive)
+	})
+
+	// Remove any DC offset.
+	y := wet - d.dcX[channel] + 0.995*d.dcY[channel]
+	d.dcX[channel], d.dcY[channel] = wet, y
+
+	return x*(1-mix) + y*output*mix
+}
+
+// setOversampling sets the oversampling factor. It must be called with the lock held.
+func (d *Distortion) setOversampling(factor int) {
+	for channel := range d.oversamplers {
+		d.oversamplers[channel] = newOversampler(factor)
+	}
+}
+
+// SetOversampling sets how many times faster than the sample rate the waveshaper runs. It can be 1, 2 or 4.
+// Higher factors alias less but take more work.
+func (d *Distortion) SetOversampling(factor int) {
+	switch {
+	case factor <= 1:
+		factor = 1
+	case factor <= 2:
+		factor = 2
+	default:
+		factor = 4
+	}
+
+	d.m.Lock()
+	d.setOversampling(factor)
+	d.m.Unlock()
+}
+
+// SetShape sets the transfer curve of the waveshaper.
+func (d *Distortion) SetShape(shape ShapeFunc) {
+	d.m.Lock()
+	d.shape = shape
+	d.m.Unlock()
+}
+
+// SetDrive sets how much the input is amplified before it is shaped.
+func (d *Distortion) SetDrive(drive float64) {
+	d.drive.Set(drive)
+}
+
+// SetOutput sets the gain applied after the waveshaper, which can be used to make up for the extra loudness added by
+// the drive.
+func (d *Distortion) SetOutput(gain float64) {
+	d.output.Set(gain)
+}
+
+// SetMix sets how much of the effect is heard, where 0 is only the input and 1 is only the distorted signal.
+func (d *Distortion) SetMix(mix float64) {
+	d.mix.Set(mix)
+}
+
+// Bitcrusher is an effect which reduces the bit depth and sample rate of its input, like an early digital sampler.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Bitcrusher struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	bits float64
+	rate float64
+	mix  *Param
+
+	// held is the sample being held until the next one is taken at the lower sample rate.
+	held  [2]float64
+	phase float64
+}
+
+// NewBitcrusher returns a new bitcrusher around a streamer. The bit depth may be fractional, the rate is the reduced
+// sample rate in hertz, and the mix is how much of the effect is heard, from 0 to 1.
+func NewBitcrusher(input Streamer, bits, rate, mix float64) *Bitcrusher {
+	return &Bitcrusher{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		bits: bits,
+		rate: rate,
+		mix:  NewParam(mix),
+
+		// Start ready to take a sample straight away.
+		phase: 1,
+	}
+}
+
+// SetInput sets the streamer which the bitcrusher processes.
+func (b *Bitcrusher) SetInput(input Streamer) {
+	b.Input = input
+}
+
+// Stream returns the crushed sample for a given point in time `t`.
+func (b *Bitcrusher) Stream(t float64) float64 {
+	x := b.Input.Stream(t)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.advance(x, x)
+
+	mix := b.mix.At(t)
+	return x*(1-mix) + b.held[0]*mix
+}
+
+// StreamStereo returns the crushed left and right channels for a given point in time `t`.
+func (b *Bitcrusher) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(b.Input, t)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.advance(l, r)
+
+	mix := b.mix.At(t)
+	return l*(1-mix) + b.held[0]*mix, r*(1-mix) + b.held[1]*mix
+}
+
+// advance moves on by one sample, taking a new crushed sample whenever one is due at the lower sample rate. It must be
+// called with the lock held.
+func (b *Bitcrusher) advance(l, r float64) {
+	rate := float64(sr)
+	if b.rate > 0 && b.rate < rate {
+		rate = b.rate
+	}
+
+	b.phase += rate / float64(sr)
+	if b.phase < 1 {
+		return
+	}
+	b.phase -= math.Floor(b.phase)
+
+	b.held[0] = b.quantise(l)
+	b.held[1] = b.quantise(r)
+}
+
+// quantise rounds a sample to the bit depth. It must be called with the lock held.
+func (b *Bitcrusher) quantise(x float64) float64 {
+	if b.bits <= 0 {
+		return x
+	}
+
+	levels := math.Pow(2, b.bits-1)
+	return math.Round(x*levels) / levels
+}
+
+// SetBits sets the bit depth, which may be fractional. A bit depth of 0 leaves the samples alone.
+func (b *Bitcrusher) SetBits(bits float64) {
+	b.m.Lock()
+	b.bits = bits
+	b.m.Unlock()
+}
+
+// SetRate sets the reduced sample rate in hertz. A rate of 0 leaves the sample rate alone.
+func (b *Bitcrusher) SetRate(rate float64) {
+	b.m.Lock()
+	b.rate = rate
+	b.m.Unlock()
+}
+
+// SetMix sets how much of the effect is heard, where 0 is only the input and 1 is only the crushed signal.
+func (b *Bitcrusher) SetMix(mix float64) {
+	b.mix.Set(mix)
+}
@@ -0,0 +1,474 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// timeCoefficient returns the coefficient of a one-pole smoother which gets about two thirds of the way to its
+// target in the given number of seconds.
+func timeCoefficient(seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+
+	return math.Exp(-1 / (seconds * float64(sr)))
+}
+
+// Compressor is a feed-forward compressor, which turns down its input when it gets louder than the threshold. It
+// listens to its input unless a sidechain is set, in which case it listens to that instead, for example to duck a
+// pad whenever the kick drum plays. Both channels are turned down together so that the stereo image doesn't move.
+type Compressor struct {
+	Input Streamer
+	// Sidechain, if set, is the streamer the compressor listens to. It is streamed once for each sample, so it
+	// shouldn't be something which is also playing elsewhere, like a synth already added to a mixer.
+	Sidechain Streamer
+
+	m *sync.Mutex
+
+	threshold float64
+	ratio     float64
+	knee      float64
+	makeup    *Param
+	attack    float64
+	release   float64
+
+	// reduction is the current gain reduction in decibels.
+	reduction float64
+}
+
+// NewCompressor returns a new compressor around a streamer. The threshold is in decibels, the ratio is how much a
+// level above the threshold is reduced by (4 turns 4dB over into 1dB over), and the attack and release are how
+// quickly the compressor reacts, in seconds.
+func NewCompressor(input Streamer, threshold, ratio, attack, release float64) *Compressor {
+	return &Compressor{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		threshold: threshold,
+		ratio:     math.Max(1, ratio),
+		makeup:    NewParam(0),
+		attack:    attack,
+		release:   release,
+	}
+}
+
+// SetInput sets the streamer which the compressor processes.
+func (c *Compressor) SetInput(input Streamer) {
+	c.Input = input
+}
+
+// Stream returns the compressed sample for a given point in time `t`.
+func (c *Compressor) Stream(t float64) float64 {
+	x := c.Input.Stream(t)
+
+	key := x
+	if c.Sidechain != nil {
+		key = c.Sidechain.Stream(t)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return x * c.gain(t, math.Abs(key))
+}
+
+// StreamStereo returns the compressed left and right channels for a given point in time `t`.
+func (c *Compressor) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(c.Input, t)
+
+	keyL, keyR := l, r
+	if c.Sidechain != nil {
+		keyL, keyR = StreamStereo(c.Sidechain, t)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	g := c.gain(t, math.Max(math.Abs(keyL), math.Abs(keyR)))
+	return l * g, r * g
+}
+
+// gain works out the gain to apply at time `t` for the current level of the key signal. It must be called with the
+// lock held.
+func (c *Compressor) gain(t, level float64) float64 {
+	target := c.curve(GainToDB(level))
+
+	// The reduction moves towards the target at the attack speed when it needs to increase, and the release speed
+	// when it needs to decrease.
+	coeff := timeCoefficient(c.release)
+	if target > c.reduction {
+		coeff = timeCoefficient(c.attack)
+	}
+	c.reduction = target + coeff*(c.reduction-target)
+
+	return DBToGain(c.makeup.At(t) - c.reduction)
+}
+
+// curve returns how many decibels a level should be reduced by. The knee smooths the transition around the
+// threshold so that the compression starts gradually. It must be called with the lock held.
+func (c *Compressor) curve(db float64) float64 {
+	over := db - c.threshold
+	slope := 1 - 1/c.ratio
+
+	switch {
+	case 2*over <= -c.knee:
+		return 0
+	case 2*math.Abs(over) < c.knee:
+		return slope * (over + c.knee/2) * (over + c.knee/2) / (2 * c.knee)
+	}
+
+	return slope * over
+}
+
+// SetThreshold sets the level in decibels above which the compressor starts turning down its input.
+func (c *Compressor) SetThreshold(db float64) {
+	c.m.Lock()
+	c.threshold = db
+	c.m.Unlock()
+}
+
+// SetRatio sets how much a level above the threshold is reduced by. A very high ratio acts like a limiter.
+func (c *Compressor) SetRatio(ratio float64) {
+	c.m.Lock()
+	c.ratio = math.Max(1, ratio)
+	c.m.Unlock()
+}
+
+// SetAttack sets how quickly the compressor turns down its input, in seconds.
+func (c *Compressor) SetAttack(seconds float64) {
+	c.m.Lock()
+	c.attack = seconds
+	c.m.Unlock()
+}
+
+// SetRelease sets how quickly the compressor lets go once its input gets quieter, in seconds.
+func (c *Compressor) SetRelease(seconds float64) {
+	c.m.Lock()
+	c.release = seconds
+	c.m.Unlock()
+}
+
+// SetKnee sets the width of the soft knee in decibels. A knee of 0 is a hard knee.
+func (c *Compressor) SetKnee(db float64) {
+	c.m.Lock()
+	c.knee = math.Max(0, db)
+	c.m.Unlock()
+}
+
+// SetMakeup sets the gain in decibels added after compression to make up for the lost loudness.
+func (c *Compressor) SetMakeup(db float64) {
+	c.makeup.Set(db)
+}
+
+// GainReduction returns how much the compressor is currently turning its input down by, in decibels.
+func (c *Compressor) GainReduction() float64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.reduction
+}
+
+// DefaultLookahead is the lookahead used by NewLimiter, in seconds.
+const DefaultLookahead = 0.005
+
+// Limiter is a brickwall limiter, which makes sure its output never goes above the ceiling. It delays its input by
+// the lookahead so that it can start turning down before a peak arrives rather than clipping it. It is usually put on
+// the output of a mixer, after any other effects:
+//
+//	synth.GlobalMixer.Output().Add(synth.NewLimiter(nil, -0.3, 0.1))
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Limiter struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	ceiling float64
+	release float64
+
+	lookahead int
+	delays    [2]*delayLine
+
+	// The gain each sample needs is held for the length of the lookahead using a queue of the smallest gains, so
+	// that the gain reaches its lowest point by the time the peak comes out of the delay.
+	held     []heldGain
+	n        int
+	released float64
+
+	// The held gain is smoothed by averaging it over the lookahead, which turns the steps into ramps.
+	window []float64
+	sum    float64
+	pos    int
+}
+
+// heldGain is a gain needed by one sample, in the limiter's queue of the smallest gains.
+type heldGain struct {
+	n    int
+	gain float64
+}
+
+// NewLimiter returns a new limiter around a streamer with the default lookahead. The ceiling is the loudest the
+// output can be, in decibels, and the release is how quickly the limiter lets go after a peak, in seconds.
+func NewLimiter(input Streamer, ceiling, release float64) *Limiter {
+	return NewLookaheadLimiter(input, ceiling, release, DefaultLookahead)
+}
+
+// NewLookaheadLimiter returns a new limiter around a streamer with a lookahead in seconds. Longer lookaheads let the
+// limiter turn down more gently, but delay the sound more.
+func NewLookaheadLimiter(input Streamer, ceiling, release, lookahead float64) *Limiter {
+	n := int(math.Max(1, lookahead*float64(sr)))
+
+	l := &Limiter{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		ceiling: ceiling,
+		release: release,
+
+		lookahead: n,
+		delays:    [2]*delayLine{newDelayLine(n), newDelayLine(n)},
+
+		released: 1,
+		window:   make([]float64, n),
+		sum:      float64(n),
+	}
+
+	for i := range l.window {
+		l.window[i] = 1
+	}
+
+	return l
+}
+
+// SetInput sets the streamer which the limiter processes.
+func (l *Limiter) SetInput(input Streamer) {
+	l.Input = input
+}
+
+// Stream returns the limited sample for a given point in time `t`.
+func (l *Limiter) Stream(t float64) float64 {
+	x := l.Input.Stream(t)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	g := l.gain(math.Abs(x))
+	ceiling := DBToGain(l.ceiling)
+
+	l.delays[0].write(x)
+	return clamp(l.delays[0].read(float64(l.lookahead))*g, ceiling)
+}
+
+// StreamStereo returns the limited left and right channels for a given point in time `t`.
+func (l *Limiter) StreamStereo(t float64) (float64, float64) {
+	left, right := StreamStereo(l.Input, t)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	g := l.gain(math.Max(math.Abs(left), math.Abs(right)))
+	ceiling := DBToGain(l.ceiling)
+
+	l.delays[0].write(left)
+	l.delays[1].write(right)
+
+	delay := float64(l.lookahead)
+	return clamp(l.delays[0].read(delay)*g, ceiling), clamp(l.delays[1].read(delay)*g, ceiling)
+}
+
+// gain takes the peak level of the newest sample and returns the gain for the sample coming out of the delay. It
+// must be called with the lock held.
+func (l *Limiter) gain(peak float64) float64 {
+	needed := 1.0
+	if ceiling := DBToGain(l.ceiling); peak > ceiling {
+		needed = ceiling / peak
+	}
+
+	// Keep the queue in increasing order of gain, dropping any gains which have left the window.
+	for len(l.held) > 0 && l.held[len(l.held)-1].gain >= needed {
+		l.held = l.held[:len(l.held)-1]
+	}
+	l.held = append(l.held, heldGain{n: l.n, gain: needed})
+
+	for l.held[0].n <= l.n-l.lookahead {
+		l.held = l.held[1:]
+	}
+	l.n++
+
+	// The gain drops straight away, but recovers at the release speed.
+	minimum := l.held[0].gain
+	if minimum < l.released {
+		l.released = minimum
+	} else {
+		coeff := timeCoefficient(l.release)
+		l.released = minimum + coeff*(l.released-minimum)
+	}
+
+	l.sum += l.released - l.window[l.pos]
+	l.window[l.pos] = l.released
+	l.pos = (l.pos + 1) % len(l.window)
+
+	return l.sum / float64(len(l.window))
+}
+
+// SetCeiling sets the loudest the output can be, in decibels.
+func (l *Limiter) SetCeiling(db float64) {
+	l.m.Lock()
+	l.ceiling = db
+	l.m.Unlock()
+}
+
+// SetRelease sets how quickly the limiter lets go after a peak, in seconds.
+func (l *Limiter) SetRelease(seconds float64) {
+	l.m.Lock()
+	l.release = seconds
+	l.m.Unlock()
+}
+
+// Latency returns how long the limiter delays its input by, in seconds.
+func (l *Limiter) Latency() float64 {
+	return float64(l.lookahead) / float64(sr)
+}
+
+// clamp limits a sample to the range -limit to limit.
+func clamp(x, limit float64) float64 {
+	return math.Max(-limit, math.Min(limit, x))
+}
+
+// Gate is a noise gate, which silences its input when it is quieter than the threshold, such as the hiss between
+// notes. Like the compressor, it can listen to a sidechain instead of its input.
+type Gate struct {
+	Input Streamer
+	// Sidechain, if set, is the streamer the gate listens to. It is streamed once for each sample, so it shouldn't be
+	// something which is also playing elsewhere, like a synth already added to a mixer.
+	Sidechain Streamer
+
+	m *sync.Mutex
+
+	threshold float64
+	floor     float64
+	attack    float64
+	hold      float64
+	release   float64
+
+	level  float64
+	gain   float64
+	closed int
+}
+
+// NewGate returns a new noise gate around a streamer. The threshold is in decibels, the attack is how quickly the
+// gate opens and the release is how quickly it closes, in seconds.
+func NewGate(input Streamer, threshold, attack, release float64) *Gate {
+	return &Gate{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		threshold: threshold,
+		attack:    attack,
+		release:   release,
+	}
+}
+
+// SetInput sets the streamer which the gate processes.
+func (g *Gate) SetInput(input Streamer) {
+	g.Input = input
+}
+
+// Stream returns the gated sample for a given point in time `t`.
+func (g *Gate) Stream(t float64) float64 {
+	x := g.Input.Stream(t)
+
+	key := x
+	if g.Sidechain != nil {
+		key = g.Sidechain.Stream(t)
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	return x * g.process(math.Abs(key))
+}
+
+// StreamStereo returns the gated left and right channels for a given point in time `t`.
+func (g *Gate) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(g.Input, t)
+
+	keyL, keyR := l, r
+	if g.Sidechain != nil {
+		keyL, keyR = StreamStereo(g.Sidechain, t)
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	gain := g.process(math.Max(math.Abs(keyL), math.Abs(keyR)))
+	return l * gain, r * gain
+}
+
+// process works out the gain of the gate for the current level of the key signal. It must be called with the lock
+// held.
+func (g *Gate) process(peak float64) float64 {
+	// The level follows peaks straight away and falls away over about 10ms, so that it doesn't flicker with each
+	// cycle of a low note.
+	if peak > g.level {
+		g.level = peak
+	} else {
+		g.level *= timeCoefficient(0.01)
+	}
+
+	target := g.floor
+	if GainToDB(g.level) >= g.threshold {
+		g.closed = 0
+		target = 1
+	} else if float64(g.closed) < g.hold*float64(sr) {
+		// The gate stays open for the hold time after the level drops.
+		g.closed++
+		target = 1
+	}
+
+	coeff := timeCoefficient(g.release)
+	if target > g.gain {
+		coeff = timeCoefficient(g.attack)
+	}
+	g.gain = target + coeff*(g.gain-target)
+
+	return g.gain
+}
+
+// SetThreshold sets the level in decibels below which the gate closes.
+func (g *Gate) SetThreshold(db float64) {
+	g.m.Lock()
+	g.threshold = db
+	g.m.Unlock()
+}
+
+// SetAttack sets how quickly the gate opens, in seconds.
+func (g *Gate) SetAttack(seconds float64) {
+	g.m.Lock()
+	g.attack = seconds
+	g.m.Unlock()
+}
+
+// SetHold sets how long the gate stays open after the level drops below the threshold, in seconds.
+func (g *Gate) SetHold(seconds float64) {
+	g.m.Lock()
+	g.hold = seconds
+	g.m.Unlock()
+}
+
+// SetRelease sets how quickly the gate closes, in seconds.
+func (g *Gate) SetRelease(seconds float64) {
+	g.m.Lock()
+	g.release = seconds
+	g.m.Unlock()
+}
+
+// SetRange sets how far the gate turns down its input when it is closed, in decibels. The default is to silence it
+// completely.
+func (g *Gate) SetRange(db float64) {
+	g.m.Lock()
+	g.floor = DBToGain(-math.Abs(db))
+	g.m.Unlock()
+}
@@ -1,6 +1,6 @@
 package synth
 
-import "fmt"
+import "math"
 
 // Envelope is the interface representing an envelope. Given a time, relative to the start of the envelope,
 // it will return an amplitude in the range 0 to 1.
@@ -14,6 +14,34 @@ type Envelope interface {
 	Started() bool
 }
 
+// TimeScaler is implemented by envelopes whose durations can be stretched or shrunk, for example in response to the
+// velocity of a note. A scale of 1 leaves the durations unchanged, and a scale of 0 makes them as short as possible.
+type TimeScaler interface {
+	SetTimeScale(scale float64)
+}
+
+// envTimeScale is the time scale of an envelope. The zero value leaves the durations unscaled, so that zero-valued
+// envelopes behave normally.
+type envTimeScale struct {
+	scale  float64
+	scaled bool
+}
+
+// set sets the time scale.
+func (s *envTimeScale) set(scale float64) {
+	s.scale, s.scaled = scale, true
+}
+
+// apply scales a duration. Every stage is kept at least one sample long, so that a stage of 0 or one scaled down to
+// nothing gives the shortest possible ramp instead of dividing by zero.
+func (s envTimeScale) apply(d float64) float64 {
+	if s.scaled {
+		d *= s.scale
+	}
+
+	return math.Max(d, 1/float64(sr))
+}
+
 // ADEnvelope is an envelope with only an attack and decay phase. There is no sustain so all uses of this
 // envelope last the same amount of time.
 // Since there is no concept of 'release', calling .Release() does nothing.
@@ -23,6 +51,7 @@ type ADEnvelope struct {
 	DecayDuration  float64
 
 	attackTime float64
+	timeScale  envTimeScale
 	started    bool
 }
 
@@ -34,15 +63,17 @@ func (env *ADEnvelope) GetAmplitude(t float64) float64 {
 	}
 
 	current := t - env.attackTime
+	attack := env.timeScale.apply(env.AttackDuration)
+	decay := env.timeScale.apply(env.DecayDuration)
 
 	// Attack
-	if current <= env.AttackDuration {
-		return (current / env.AttackDuration) * env.Amplitude
+	if current <= attack {
+		return (current / attack) * env.Amplitude
 	}
 
 	// Decay
-	if current > env.AttackDuration && current <= env.AttackDuration+env.DecayDuration {
-		return env.Amplitude - (env.Amplitude * ((current - env.AttackDuration) / env.DecayDuration))
+	if current > attack && current <= attack+decay {
+		return env.Amplitude - (env.Amplitude * ((current - attack) / decay))
 	}
 
 	// After decay
@@ -51,7 +82,6 @@ func (env *ADEnvelope) GetAmplitude(t float64) float64 {
 
 // Attack triggers the start of the attack phase.
 func (env *ADEnvelope) Attack(t float64) {
-	fmt.Println("attack called", t)
 	env.started = true
 	env.attackTime = t
 }
@@ -61,7 +91,12 @@ func (env *ADEnvelope) Release(t float64) {}
 
 // Finished returns true if the envelope has finished.
 func (env *ADEnvelope) Finished() bool {
-	return getGlobalTime() > (env.attackTime + env.AttackDuration + env.DecayDuration)
+	return env.started && getGlobalTime() > (env.attackTime+env.timeScale.apply(env.AttackDuration)+env.timeScale.apply(env.DecayDuration))
+}
+
+// SetTimeScale scales the attack and decay durations of the envelope.
+func (env *ADEnvelope) SetTimeScale(scale float64) {
+	env.timeScale.set(scale)
 }
 
 // Started returns true if the envelope has started.
@@ -91,6 +126,7 @@ type ASREnvelope struct {
 
 	attackTime  float64
 	releaseTime float64
+	timeScale   envTimeScale
 	isOn        bool
 
 	started bool
@@ -104,21 +140,23 @@ func (env *ASREnvelope) GetAmplitude(t float64) float64 {
 	}
 
 	current := t - env.attackTime
+	attack := env.timeScale.apply(env.AttackDuration)
+	release := env.timeScale.apply(env.ReleaseDuration)
 
 	// Attack
-	if current <= env.AttackDuration {
-		return (current / env.AttackDuration) * env.Amplitude
+	if current <= attack {
+		return (current / attack) * env.Amplitude
 	}
 
 	// Sustain
-	if current > env.AttackDuration && env.isOn {
+	if current > attack && env.isOn {
 		return env.Amplitude
 	}
 
 	// Release
 	current = t - env.releaseTime
-	if current <= env.ReleaseDuration {
-		return env.Amplitude - (env.Amplitude * ((current) / env.ReleaseDuration))
+	if current <= release {
+		return env.Amplitude - (env.Amplitude * ((current) / release))
 	}
 
 	// After full release
@@ -139,9 +177,14 @@ func (env *ASREnvelope) Release(t float64) {
 	env.isOn = false
 }
 
-// Finished returns true if the envelope has finished.
+// Finished returns true if the envelope has finished. It is never finished while the key is still held.
 func (env *ASREnvelope) Finished() bool {
-	return getGlobalTime() > (env.releaseTime + env.ReleaseDuration)
+	return env.started && !env.isOn && getGlobalTime() > (env.releaseTime+env.timeScale.apply(env.ReleaseDuration))
+}
+
+// SetTimeScale scales the attack and release durations of the envelope.
+func (env *ASREnvelope) SetTimeScale(scale float64) {
+	env.timeScale.set(scale)
 }
 
 // Started returns true if the envelope has started.
@@ -169,6 +212,7 @@ type ADSREnvelope struct {
 
 	attackTime  float64
 	releaseTime float64
+	timeScale   envTimeScale
 	isOn        bool
 
 	finished bool
@@ -183,26 +227,29 @@ func (env *ADSREnvelope) GetAmplitude(t float64) float64 {
 	}
 
 	current := t - env.attackTime
+	attack := env.timeScale.apply(env.AttackDuration)
+	decay := env.timeScale.apply(env.DecayDuration)
+	release := env.timeScale.apply(env.ReleaseDuration)
 
 	// Attack
-	if current <= env.AttackDuration {
-		return (current / env.AttackDuration) * env.AttackAmplitude
+	if current <= attack {
+		return (current / attack) * env.AttackAmplitude
 	}
 
 	// Decay
-	if current > env.AttackDuration && current <= (env.AttackDuration+env.DecayDuration) {
-		return env.AttackAmplitude - ((env.AttackAmplitude - env.SustainAmplitude) * ((current - env.AttackDuration) / env.DecayDuration))
+	if current > attack && current <= (attack+decay) {
+		return env.AttackAmplitude - ((env.AttackAmplitude - env.SustainAmplitude) * ((current - attack) / decay))
 	}
 
 	// Sustain
-	if current > (env.AttackDuration+env.DecayDuration) && env.isOn {
+	if current > (attack+decay) && env.isOn {
 		return env.SustainAmplitude
 	}
 
 	// Release
 	current = t - env.releaseTime
-	if current <= env.ReleaseDuration {
-		return env.SustainAmplitude - (env.SustainAmplitude * ((current) / env.ReleaseDuration))
+	if current <= release {
+		return env.SustainAmplitude - (env.SustainAmplitude * ((current) / release))
 	}
 
 	// After full release
@@ -225,9 +272,14 @@ func (env *ADSREnvelope) Release(t float64) {
 	env.isOn = false
 }
 
-// Finished returns true if the envelope has finished.
+// Finished returns true if the envelope has finished. It is never finished while the key is still held.
 func (env *ADSREnvelope) Finished() bool {
-	return getGlobalTime() > (env.releaseTime + env.ReleaseDuration)
+	return env.started && !env.isOn && getGlobalTime() > (env.releaseTime+env.timeScale.apply(env.ReleaseDuration))
+}
+
+// SetTimeScale scales the attack, decay and release durations of the envelope.
+func (env *ADSREnvelope) SetTimeScale(scale float64) {
+	env.timeScale.set(scale)
 }
 
 // Started returns true if the envelope has started.
@@ -0,0 +1,330 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// EQBand is one band of an equaliser.
+type EQBand struct {
+	// Type is usually Peaking, LowShelf or HighShelf, but any biquad filter type can be used.
+	Type FilterType
+	// Freq is the centre or corner frequency in hertz.
+	Freq float64
+	// Gain is the boost or cut in decibels.
+	Gain float64
+	// Q controls the width of the band. Higher values give a narrower band.
+	Q float64
+}
+
+// flat returns true if the band has no effect at a gain, so it can be skipped.
+func (b EQBand) flat(gain float64) bool {
+	return gain == 0 && (b.Type == Peaking || b.Type == LowShelf || b.Type == HighShelf)
+}
+
+// eqBank is a set of biquads for each channel, one for each band, which are applied one after the other. The gain of
+// each band ramps to new values so that it doesn't click.
+type eqBank struct {
+	bands   []EQBand
+	gains   []*Param
+	filters [2][]*Biquad
+
+	// active is whether each band was processed for the last sample. A band's filter is reset when it starts being
+	// processed again, since its memory of previous samples is out of date and would cause a transient.
+	active [2][]bool
+}
+
+// add adds a band to the bank.
+func (e *eqBank) add(band EQBand) {
+	e.bands = append(e.bands, band)
+	e.gains = append(e.gains, NewParam(band.Gain))
+
+	for channel := range e.filters {
+		f := NewBiquad(band.Type, band.Freq, band.Q)
+		f.Gain = band.Gain
+		e.filters[channel] = append(e.filters[channel], f)
+		e.active[channel] = append(e.active[channel], false)
+	}
+}
+
+// set changes a band in the bank.
+func (e *eqBank) set(i int, band EQBand) {
+	e.bands[i] = band
+	e.gains[i].Set(band.Gain)
+
+	for channel := range e.filters {
+		f := e.filters[channel][i]
+		f.Type, f.Cutoff, f.Q = band.Type, band.Freq, band.Q
+	}
+}
+
+// remove removes a band from the bank.
+func (e *eqBank) remove(i int) {
+	e.bands = append(e.bands[:i], e.bands[i+1:]...)
+	e.gains = append(e.gains[:i], e.gains[i+1:]...)
+
+	for channel := range e.filters {
+		e.filters[channel] = append(e.filters[channel][:i], e.filters[channel][i+1:]...)
+		e.active[channel] = append(e.active[channel][:i], e.active[channel][i+1:]...)
+	}
+}
+
+// process equalises a sample from one channel at time `t`.
+func (e *eqBank) process(channel int, x, t float64) float64 {
+	for i, f := range e.filters[channel] {
+		gain := e.gains[i].At(t)
+		if e.bands[i].flat(gain) {
+			e.active[channel][i] = false
+			continue
+		}
+
+		if !e.active[channel][i] {
+			f.Reset()
+			e.active[channel][i] = true
+		}
+
+		f.Gain = gain
+		x = f.Process(x)
+	}
+
+	return x
+}
+
+// ParametricEQ is an equaliser made of a number of bands whose type, frequency, gain and width can all be changed.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type ParametricEQ struct {
+	Input Streamer
+
+	m    *sync.Mutex
+	bank eqBank
+}
+
+// NewParametricEQ returns a new parametric EQ around a streamer with the usual layout of a low shelf, a number of
+// peaking bands spread evenly through the octaves and a high shelf. Every band starts off flat.
+func NewParametricEQ(input Streamer, peaks int) *ParametricEQ {
+	bands := []EQBand{{Type: LowShelf, Freq: 80, Q: math.Sqrt2 / 2}}
+
+	// The peaking bands are spread between 150Hz and 6kHz.
+	for i := 0; i < peaks; i++ {
+		pos := 0.5
+		if peaks > 1 {
+			pos = float64(i) / float64(peaks-1)
+		}
+
+		freq := 150 * math.Pow(6000/150.0, pos)
+		bands = append(bands, EQBand{Type: Peaking, Freq: freq, Q: 1})
+	}
+
+	bands = append(bands, EQBand{Type: HighShelf, Freq: 10000, Q: math.Sqrt2 / 2})
+
+	return NewParametricEQWith(input, bands...)
+}
+
+// NewParametricEQWith returns a new parametric EQ around a streamer with the given bands.
+func NewParametricEQWith(input Streamer, bands ...EQBand) *ParametricEQ {
+	eq := &ParametricEQ{
+		Input: input,
+		m:     &sync.Mutex{},
+	}
+
+	for _, band := range bands {
+		eq.bank.add(band)
+	}
+
+	return eq
+}
+
+// SetInput sets the streamer which the EQ processes.
+func (eq *ParametricEQ) SetInput(input Streamer) {
+	eq.Input = input
+}
+
+// Stream returns the equalised sample for a given point in time `t`.
+func (eq *ParametricEQ) Stream(t float64) float64 {
+	x := eq.Input.Stream(t)
+
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.process(0, x, t)
+}
+
+// StreamStereo returns the equalised left and right channels for a given point in time `t`.
+func (eq *ParametricEQ) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(eq.Input, t)
+
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.process(0, l, t), eq.bank.process(1, r, t)
+}
+
+// Bands returns a copy of the bands of the EQ.
+func (eq *ParametricEQ) Bands() []EQBand {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return append([]EQBand(nil), eq.bank.bands...)
+}
+
+// Band returns one of the bands of the EQ.
+func (eq *ParametricEQ) Band(i int) EQBand {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.bands[i]
+}
+
+// SetBand changes one of the bands of the EQ.
+func (eq *ParametricEQ) SetBand(i int, band EQBand) {
+	eq.m.Lock()
+	eq.bank.set(i, band)
+	eq.m.Unlock()
+}
+
+// SetGain changes the gain of one of the bands of the EQ, in decibels.
+func (eq *ParametricEQ) SetGain(i int, gain float64) {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	band := eq.bank.bands[i]
+	band.Gain = gain
+	eq.bank.set(i, band)
+}
+
+// AddBand adds a band to the EQ and returns its index.
+func (eq *ParametricEQ) AddBand(band EQBand) int {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	eq.bank.add(band)
+	return len(eq.bank.bands) - 1
+}
+
+// RemoveBand removes a band from the EQ. The bands after it move down by one.
+func (eq *ParametricEQ) RemoveBand(i int) {
+	eq.m.Lock()
+	eq.bank.remove(i)
+	eq.m.Unlock()
+}
+
+// The centre frequencies of the bands of the graphic EQs, in hertz.
+var (
+	octaveBands      = []float64{31.5, 63, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+	thirdOctaveBands = []float64{
+		20, 25, 31.5, 40, 50, 63, 80, 100, 125, 160, 200, 250, 315, 400, 500, 630, 800, 1000, 1250, 1600, 2000, 2500,
+		3150, 4000, 5000, 6300, 8000, 10000, 12500, 16000, 20000,
+	}
+)
+
+// GraphicEQ is an equaliser with a fixed set of peaking bands, like the row of sliders on a mixing desk. Only the gain
+// of each band can be changed.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type GraphicEQ struct {
+	Input Streamer
+
+	m    *sync.Mutex
+	bank eqBank
+}
+
+// NewGraphicEQ returns a new 10-band graphic EQ around a streamer, with one band per octave.
+func NewGraphicEQ(input Streamer) *GraphicEQ {
+	// A Q of about 1.4 makes each band an octave wide.
+	return newGraphicEQ(input, octaveBands, 1.41)
+}
+
+// NewGraphicEQ31 returns a new 31-band graphic EQ around a streamer, with one band per third of an octave.
+func NewGraphicEQ31(input Streamer) *GraphicEQ {
+	// A Q of about 4.3 makes each band a third of an octave wide.
+	return newGraphicEQ(input, thirdOctaveBands, 4.32)
+}
+
+// newGraphicEQ returns a new graphic EQ with bands at the given frequencies.
+func newGraphicEQ(input Streamer, freqs []float64, q float64) *GraphicEQ {
+	eq := &GraphicEQ{
+		Input: input,
+		m:     &sync.Mutex{},
+	}
+
+	for _, freq := range freqs {
+		eq.bank.add(EQBand{Type: Peaking, Freq: freq, Q: q})
+	}
+
+	return eq
+}
+
+// SetInput sets the streamer which the EQ processes.
+func (eq *GraphicEQ) SetInput(input Streamer) {
+	eq.Input = input
+}
+
+// Stream returns the equalised sample for a given point in time `t`.
+func (eq *GraphicEQ) Stream(t float64) float64 {
+	x := eq.Input.Stream(t)
+
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.process(0, x, t)
+}
+
+// StreamStereo returns the equalised left and right channels for a given point in time `t`.
+func (eq *GraphicEQ) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(eq.Input, t)
+
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.process(0, l, t), eq.bank.process(1, r, t)
+}
+
+// Frequencies returns the centre frequency of each band, in hertz.
+func (eq *GraphicEQ) Frequencies() []float64 {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	freqs := make([]float64, len(eq.bank.bands))
+	for i, band := range eq.bank.bands {
+		freqs[i] = band.Freq
+	}
+
+	return freqs
+}
+
+// Gain returns the gain of a band in decibels.
+func (eq *GraphicEQ) Gain(i int) float64 {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	return eq.bank.bands[i].Gain
+}
+
+// SetGain sets the gain of a band in decibels.
+func (eq *GraphicEQ) SetGain(i int, gain float64) {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	band := eq.bank.bands[i]
+	band.Gain = gain
+	eq.bank.set(i, band)
+}
+
+// SetGains sets the gain of every band at once, in decibels. Any extra gains are ignored.
+func (eq *GraphicEQ) SetGains(gains ...float64) {
+	eq.m.Lock()
+	defer eq.m.Unlock()
+
+	for i, gain := range gains {
+		if i >= len(eq.bank.bands) {
+			break
+		}
+
+		band := eq.bank.bands[i]
+		band.Gain = gain
+		eq.bank.set(i, band)
+	}
+}
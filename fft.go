@@ -0,0 +1,78 @@
+package synth
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// fft performs fast Fourier transforms of a fixed size, which must be a power of two. The twiddle factors and
+// bit-reversal table are worked out once so that repeated transforms, such as in block-based effects, are cheap.
+type fft struct {
+	n        int
+	twiddles []complex128
+	rev      []int
+}
+
+// newFFT returns a new FFT of size `n`, which must be a power of two.
+func newFFT(n int) *fft {
+	if n < 1 || n&(n-1) != 0 {
+		panic("synth: fft size must be a power of two")
+	}
+
+	f := &fft{
+		n:        n,
+		twiddles: make([]complex128, n/2),
+		rev:      make([]int, n),
+	}
+
+	for i := range f.twiddles {
+		f.twiddles[i] = cmplx.Rect(1, -2*math.Pi*float64(i)/float64(n))
+	}
+
+	shift := 64 - bits.Len(uint(n-1))
+	for i := range f.rev {
+		if n > 1 {
+			f.rev[i] = int(bits.Reverse64(uint64(i)) >> uint(shift))
+		}
+	}
+
+	return f
+}
+
+// transform replaces `x` with its discrete Fourier transform, or its inverse if `inverse` is true. The inverse is
+// scaled by 1/n so that transforming forwards and back gives the original values.
+func (f *fft) transform(x []complex128, inverse bool) {
+	for i, j := range f.rev {
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= f.n; size *= 2 {
+		half := size / 2
+		step := f.n / size
+
+		for start := 0; start < f.n; start += size {
+			for k := 0; k < half; k++ {
+				w := f.twiddles[k*step]
+				if inverse {
+					w = cmplx.Conj(w)
+				}
+
+				a := x[start+k]
+				b := w * x[start+k+half]
+
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(f.n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// FilterType is the shape of a biquad filter's frequency response.
+type FilterType int
+
+const (
+	// LowPass lets frequencies below the cutoff through.
+	LowPass FilterType = iota
+	// HighPass lets frequencies above the cutoff through.
+	HighPass
+	// BandPass lets frequencies around the cutoff through.
+	BandPass
+	// Notch removes frequencies around the cutoff.
+	Notch
+	// Peaking boosts or cuts frequencies around the cutoff by the gain.
+	Peaking
+	// LowShelf boosts or cuts frequencies below the cutoff by the gain.
+	LowShelf
+	// HighShelf boosts or cuts frequencies above the cutoff by the gain.
+	HighShelf
+)
+
+// VoiceFilter is a filter which can be placed inside a Synth to process the output of each voice.
+type VoiceFilter interface {
+	Process(x float64) float64
+	SetCutoff(cutoff float64)
+}
+
+// Biquad is a second-order filter using the formulas from Robert Bristow-Johnson's Audio EQ Cookbook.
+// It processes one sample at a time and isn't safe for concurrent use, so use a Filter to wrap a Streamer.
+// https://www.w3.org/TR/audio-eq-cookbook/
+type Biquad struct {
+	Type FilterType
+
+	// Cutoff is the cutoff or centre frequency in hertz.
+	Cutoff float64
+	// Q controls the width of the filter. Higher values give a narrower band or a more resonant peak.
+	Q float64
+	// Gain is the boost or cut in decibels used by the peaking and shelving filters.
+	Gain float64
+
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+
+	// The parameters the coefficients were last calculated for.
+	calculated                  bool
+	lastType                    FilterType
+	lastCutoff, lastQ, lastGain float64
+}
+
+// NewBiquad returns a new biquad filter.
+func NewBiquad(typ FilterType, cutoff, q float64) *Biquad {
+	return &Biquad{
+		Type:   typ,
+		Cutoff: cutoff,
+		Q:      q,
+	}
+}
+
+// Process filters a single sample.
+func (f *Biquad) Process(x float64) float64 {
+	if !f.calculated || f.Type != f.lastType || f.Cutoff != f.lastCutoff || f.Q != f.lastQ || f.Gain != f.lastGain {
+		f.calculate()
+	}
+
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+
+	return y
+}
+
+// Reset clears the filter's memory of previous samples.
+func (f *Biquad) Reset() {
+	f.x1, f.x2, f.y1, f.y2 = 0, 0, 0, 0
+}
+
+// SetCutoff sets the cutoff or centre frequency of the filter in hertz.
+func (f *Biquad) SetCutoff(cutoff float64) {
+	f.Cutoff = cutoff
+}
+
+// SetQ sets the Q of the filter.
+func (f *Biquad) SetQ(q float64) {
+	f.Q = q
+}
+
+// SetGain sets the gain of the filter in decibels.
+func (f *Biquad) SetGain(gain float64) {
+	f.Gain = gain
+}
+
+// SetType sets the type of the filter.
+func (f *Biquad) SetType(typ FilterType) {
+	f.Type = typ
+}
+
+// calculate works out the filter coefficients from the current parameters.
+func (f *Biquad) calculate() {
+	f.calculated = true
+	f.lastType, f.lastCutoff, f.lastQ, f.lastGain = f.Type, f.Cutoff, f.Q, f.Gain
+
+	w0 := 2 * math.Pi * clampCutoff(f.Cutoff) / float64(sr)
+	cos, sin := math.Cos(w0), math.Sin(w0)
+	alpha := sin / (2 * math.Max(f.Q, 0.01))
+	a := math.Pow(10, f.Gain/40)
+
+	var b0, b1, b2, a0, a1, a2 float64
+
+	switch f.Type {
+	case LowPass:
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case HighPass:
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case BandPass:
+		b0, b1, b2 = alpha, 0, -alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Notch:
+		b0, b1, b2 = 1, -2*cos, 1
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Peaking:
+		b0, b1, b2 = 1+alpha*a, -2*cos, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cos, 1-alpha/a
+	case LowShelf:
+		sq := 2 * math.Sqrt(a) * alpha
+		b0, b1, b2 = a*((a+1)-(a-1)*cos+sq), 2*a*((a-1)-(a+1)*cos), a*((a+1)-(a-1)*cos-sq)
+		a0, a1, a2 = (a+1)+(a-1)*cos+sq, -2*((a-1)+(a+1)*cos), (a+1)+(a-1)*cos-sq
+	case HighShelf:
+		sq := 2 * math.Sqrt(a) * alpha
+		b0, b1, b2 = a*((a+1)+(a-1)*cos+sq), -2*a*((a-1)+(a+1)*cos), a*((a+1)+(a-1)*cos-sq)
+		a0, a1, a2 = (a+1)-(a-1)*cos+sq, 2*((a-1)-(a+1)*cos), (a+1)-(a-1)*cos-sq
+	default:
+		b0, a0 = 1, 1
+	}
+
+	f.b0, f.b1, f.b2 = b0/a0, b1/a0, b2/a0
+	f.a1, f.a2 = a1/a0, a2/a0
+}
+
+// clampCutoff keeps a cutoff frequency within the range a digital filter can handle.
+func clampCutoff(cutoff float64) float64 {
+	return math.Max(10, math.Min(cutoff, 0.49*float64(sr)))
+}
+
+// prewarp returns the gain coefficient of a zero-delay feedback filter for a cutoff frequency.
+func prewarp(cutoff float64) float64 {
+	return math.Tan(math.Pi * clampCutoff(cutoff) / float64(sr))
+}
+
+// Ladder is a Moog-style four-pole (24dB/octave) low-pass ladder filter. It uses a zero-delay feedback design, so it
+// stays stable when the cutoff is modulated quickly, and saturates inside the feedback loop, so it can be pushed
+// into self-oscillation.
+type Ladder struct {
+	// Cutoff is the cutoff frequency in hertz.
+	Cutoff float64
+	// Resonance is the amount of feedback, in the range 0 to 1. At 1 the filter oscillates on its own.
+	Resonance float64
+	// Drive is extra gain into the filter, which makes it saturate. A drive of 0 leaves the level unchanged.
+	Drive float64
+
+	s1, s2, s3, s4 float64
+}
+
+// NewLadder returns a new ladder filter.
+func NewLadder(cutoff, resonance float64) *Ladder {
+	return &Ladder{
+		Cutoff:    cutoff,
+		Resonance: resonance,
+	}
+}
+
+// Process filters a single sample.
+func (f *Ladder) Process(x float64) float64 {
+	g := prewarp(f.Cutoff)
+	G := g / (1 + g)
+	// A feedback gain of 4 is the edge of self-oscillation, so full resonance goes slightly beyond it and relies on
+	// the saturation to keep the level under control.
+	k := 4.2 * math.Max(0, math.Min(f.Resonance, 1))
+
+	// Each one-pole stage's output is G*input + state/(1+g), so the feedback from the last stage can be solved for
+	// without a delay.
+	b1, b2, b3, b4 := f.s1/(1+g), f.s2/(1+g), f.s3/(1+g), f.s4/(1+g)
+	feedback := G*G*G*b1 + G*G*b2 + G*b3 + b4
+
+	u := math.Tanh(((1+f.Drive)*x - k*feedback) / (1 + k*G*G*G*G))
+
+	y1 := f.stage(&f.s1, u, G)
+	y2 := f.stage(&f.s2, y1, G)
+	y3 := f.stage(&f.s3, y2, G)
+	y4 := f.stage(&f.s4, y3, G)
+
+	return y4 / (1 + f.Drive)
+}
+
+// stage runs one of the one-pole low-pass stages of the ladder.
+func (f *Ladder) stage(s *float64, x, G float64) float64 {
+	v := (x - *s) * G
+	y := v + *s
+	*s = y + v
+
+	return y
+}
+
+// Reset clears the filter's memory of previous samples.
+func (f *Ladder) Reset() {
+	f.s1, f.s2, f.s3, f.s4 = 0, 0, 0, 0
+}
+
+// SetCutoff sets the cutoff frequency of the filter in hertz.
+func (f *Ladder) SetCutoff(cutoff float64) {
+	f.Cutoff = cutoff
+}
+
+// SetResonance sets the resonance of the filter, in the range 0 to 1.
+func (f *Ladder) SetResonance(resonance float64) {
+	f.Resonance = resonance
+}
+
+// SetDrive sets the drive of the filter.
+func (f *Ladder) SetDrive(drive float64) {
+	f.Drive = drive
+}
+
+// SVF is a multimode two-pole state-variable filter. It uses a zero-delay feedback design so it stays stable when
+// the cutoff is modulated quickly.
+// https://cytomic.com/files/dsp/SvfLinearTrapOptimised2.pdf
+type SVF struct {
+	// Type is the output of the filter. LowPass, HighPass, BandPass, Notch and Peaking are supported.
+	Type FilterType
+	// Cutoff is the cutoff frequency in hertz.
+	Cutoff float64
+	// Resonance is the amount of resonance, in the range 0 to 1. At 1 the filter oscillates on its own.
+	Resonance float64
+	// Drive is extra gain into the filter, which makes it saturate. A drive of 0 keeps the filter linear.
+	Drive float64
+
+	ic1, ic2 float64
+}
+
+// NewSVF returns a new state-variable filter.
+func NewSVF(typ FilterType, cutoff, resonance float64) *SVF {
+	return &SVF{
+		Type:      typ,
+		Cutoff:    cutoff,
+		Resonance: resonance,
+	}
+}
+
+// Process filters a single sample.
+func (f *SVF) Process(x float64) float64 {
+	g := prewarp(f.Cutoff)
+	// Full resonance gives slightly negative damping so that the filter oscillates, relying on the saturation to
+	// keep the level under control.
+	k := 2 - 2.05*math.Max(0, math.Min(f.Resonance, 1))
+
+	if f.Drive > 0 {
+		x = math.Tanh((1 + f.Drive) * x)
+	}
+
+	a1 := 1 / (1 + g*(g+k))
+	a2 := g * a1
+	a3 := g * a2
+
+	v3 := x - f.ic2
+	v1 := a1*f.ic1 + a2*v3
+	v2 := f.ic2 + a2*f.ic1 + a3*v3
+
+	// Without enough damping the filter's energy can grow, so the band-pass state is softly limited when driven or
+	// close to self-oscillation.
+	if f.Drive > 0 || k < 0.05 {
+		v1 = math.Tanh(v1)
+	}
+
+	f.ic1 = 2*v1 - f.ic1
+	f.ic2 = 2*v2 - f.ic2
+
+	low, band, high := v2, v1, x-k*v1-v2
+
+	switch f.Type {
+	case HighPass:
+		return high
+	case BandPass:
+		return band
+	case Notch:
+		return low + high
+	case Peaking:
+		return low - high
+	}
+
+	return low
+}
+
+// Reset clears the filter's memory of previous samples.
+func (f *SVF) Reset() {
+	f.ic1, f.ic2 = 0, 0
+}
+
+// SetCutoff sets the cutoff frequency of the filter in hertz.
+func (f *SVF) SetCutoff(cutoff float64) {
+	f.Cutoff = cutoff
+}
+
+// SetResonance sets the resonance of the filter, in the range 0 to 1.
+func (f *SVF) SetResonance(resonance float64) {
+	f.Resonance = resonance
+}
+
+// SetDrive sets the drive of the filter.
+func (f *SVF) SetDrive(drive float64) {
+	f.Drive = drive
+}
+
+// SetType sets the output of the filter.
+func (f *SVF) SetType(typ FilterType) {
+	f.Type = typ
+}
+
+// Filter is a streamer which filters the output of another streamer.
+type Filter struct {
+	Input Streamer
+
+	m      *sync.Mutex
+	filter VoiceFilter
+}
+
+// NewFilter returns a new biquad filter around a streamer.
+func NewFilter(input Streamer, typ FilterType, cutoff, q float64) *Filter {
+	return NewFilterWith(input, NewBiquad(typ, cutoff, q))
+}
+
+// NewLadderFilter returns a new ladder filter around a streamer.
+func NewLadderFilter(input Streamer, cutoff, resonance float64) *Filter {
+	return NewFilterWith(input, NewLadder(cutoff, resonance))
+}
+
+// NewSVFFilter returns a new state-variable filter around a streamer.
+func NewSVFFilter(input Streamer, typ FilterType, cutoff, resonance float64) *Filter {
+	return NewFilterWith(input, NewSVF(typ, cutoff, resonance))
+}
+
+// NewFilterWith returns a streamer which filters a streamer with any voice filter.
+func NewFilterWith(input Streamer, filter VoiceFilter) *Filter {
+	return &Filter{
+		Input:  input,
+		m:      &sync.Mutex{},
+		filter: filter,
+	}
+}
+
+// Stream returns the filtered sample for a given point in time `t`.
+func (f *Filter) Stream(t float64) float64 {
+	x := f.Input.Stream(t)
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.filter.Process(x)
+}
+
+// SetCutoff sets the cutoff or centre frequency of the filter in hertz.
+func (f *Filter) SetCutoff(cutoff float64) {
+	f.m.Lock()
+	f.filter.SetCutoff(cutoff)
+	f.m.Unlock()
+}
+
+// SetType sets the type of the filter, if the filter has more than one type.
+func (f *Filter) SetType(typ FilterType) {
+	f.m.Lock()
+	if filter, ok := f.filter.(interface{ SetType(FilterType) }); ok {
+		filter.SetType(typ)
+	}
+	f.m.Unlock()
+}
+
+// SetQ sets the Q of the filter, if it is a biquad filter.
+func (f *Filter) SetQ(q float64) {
+	f.m.Lock()
+	if filter, ok := f.filter.(interface{ SetQ(float64) }); ok {
+		filter.SetQ(q)
+	}
+	f.m.Unlock()
+}
+
+// SetGain sets the gain of the filter in decibels, if it is a biquad filter. It is only used by the peaking and
+// shelving filters.
+func (f *Filter) SetGain(gain float64) {
+	f.m.Lock()
+	if filter, ok := f.filter.(interface{ SetGain(float64) }); ok {
+		filter.SetGain(gain)
+	}
+	f.m.Unlock()
+}
+
+// SetResonance sets the resonance of the filter, if it is a ladder or state-variable filter.
+func (f *Filter) SetResonance(resonance float64) {
+	f.m.Lock()
+	if filter, ok := f.filter.(interface{ SetResonance(float64) }); ok {
+		filter.SetResonance(resonance)
+	}
+	f.m.Unlock()
+}
+
+// SetDrive sets the drive of the filter, if it is a ladder or state-variable filter.
+func (f *Filter) SetDrive(drive float64) {
+	f.m.Lock()
+	if filter, ok := f.filter.(interface{ SetDrive(float64) }); ok {
+		filter.SetDrive(drive)
+	}
+	f.m.Unlock()
+}
@@ -8,9 +8,9 @@ func Bell() *synth.Synth {
 		func(amp, freq, t float64) float64 {
 			output := 0.0
 
-			output += 1.00 * synth.NewSine(amp, freq).Stream(t+synth.NewSine(0.0005, 2).Stream(t))
-			output += 0.50 * synth.NewSine(amp, freq*2).Stream(t)
-			output += 0.05 * synth.NewSine(amp, freq*3).Stream(t)
+			output += 1.00 * synth.SineWave(amp, freq*(t+synth.SineWave(0.0005, 2*t)))
+			output += 0.50 * synth.SineWave(amp, freq*2*t)
+			output += 0.05 * synth.SineWave(amp, freq*3*t)
 
 			return output
 		},
@@ -9,9 +9,9 @@ func Harmonica() *synth.Synth {
 		func(amp, freq, t float64) float64 {
 			output := 0.0
 
-			output += 1.00 * synth.NewAnalogSquare(amp, freq, 30).Stream(t+synth.NewSine(0.001, 5).Stream(t))
-			output += 0.50 * synth.NewAnalogSquare(amp, freq*2, 30).Stream(t)
-			output += 0.05 * synth.NewNoise(amp).Stream(t)
+			output += 1.00 * synth.AnalogSquareWave(amp, freq*(t+synth.SineWave(0.001, 5*t)), 30)
+			output += 0.50 * synth.AnalogSquareWave(amp, freq*2*t, 30)
+			output += 0.05 * synth.NoiseWave(amp)
 
 			return output
 		},
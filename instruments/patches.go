@@ -0,0 +1,42 @@
+package instruments
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ollybritton/synth"
+)
+
+//go:embed patches/*.json
+var patchFiles embed.FS
+
+// Patch returns one of the built-in patches, such as "bell" or "harmonica".
+func Patch(name string) (*synth.Patch, error) {
+	f, err := patchFiles.Open(path.Join("patches", name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("no patch called %q", name)
+	}
+	defer f.Close()
+
+	return synth.LoadPatch(f)
+}
+
+// Patches returns the names of the built-in patches.
+func Patches() []string {
+	entries, err := patchFiles.ReadDir("patches")
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+
+	sort.Strings(names)
+
+	return names
+}
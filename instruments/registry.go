@@ -0,0 +1,180 @@
+package instruments
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ollybritton/synth"
+)
+
+// Info describes an instrument in the registry.
+type Info struct {
+	Name        string
+	Category    string
+	Description string
+
+	// Polyphony is the number of voices the instrument should be played with. If it is 0, synth.DefaultPolyphony is
+	// used.
+	Polyphony int
+	// Mono is true if the instrument plays one note at a time, using the mono settings of its synth.
+	Mono bool
+}
+
+// Constructor returns a new copy of an instrument.
+type Constructor func() (*synth.Synth, error)
+
+type instrument struct {
+	info Info
+	ctor Constructor
+	// poly builds the polyphonic synth for instruments which know how to, such as patches. Otherwise NewPoly wraps
+	// the synth from ctor itself.
+	poly func() (*synth.PolySynth, error)
+}
+
+var (
+	m        = &sync.Mutex{}
+	registry = map[string]instrument{}
+)
+
+func init() {
+	for _, name := range Patches() {
+		p, err := Patch(name)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := RegisterPatch(p); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register adds an instrument to the registry. It returns an error if an instrument with the same name is already
+// registered.
+func Register(info Info, ctor Constructor) error {
+	return register(instrument{info: info, ctor: ctor})
+}
+
+// register adds an instrument to the registry.
+func register(i instrument) error {
+	if i.info.Name == "" {
+		return fmt.Errorf("instrument has no name")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := registry[i.info.Name]; ok {
+		return fmt.Errorf("instrument %q is already registered", i.info.Name)
+	}
+
+	registry[i.info.Name] = i
+	return nil
+}
+
+// RegisterPatch adds a patch to the registry, using the name, category, description and polyphony of the patch.
+func RegisterPatch(p *synth.Patch) error {
+	info := Info{
+		Name:        p.Name,
+		Category:    p.Category,
+		Description: p.Description,
+		Polyphony:   p.Polyphony,
+		Mono:        p.Mono != nil,
+	}
+
+	return register(instrument{info: info, ctor: p.Synth, poly: p.PolySynth})
+}
+
+// RegisterPatchFile loads a patch from a file and adds it to the registry. It returns the name of the instrument.
+func RegisterPatchFile(path string) (string, error) {
+	p, err := synth.LoadPatchFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Name, RegisterPatch(p)
+}
+
+// Unregister removes an instrument from the registry.
+func Unregister(name string) {
+	m.Lock()
+	delete(registry, name)
+	m.Unlock()
+}
+
+// Lookup returns the information about an instrument.
+func Lookup(name string) (Info, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	i, ok := registry[name]
+	return i.info, ok
+}
+
+// List returns the information about every registered instrument, sorted by category and then name.
+func List() []Info {
+	m.Lock()
+	defer m.Unlock()
+
+	infos := make([]Info, 0, len(registry))
+	for _, i := range registry {
+		infos = append(infos, i.info)
+	}
+
+	sort.Slice(infos, func(a, b int) bool {
+		if infos[a].Category != infos[b].Category {
+			return infos[a].Category < infos[b].Category
+		}
+
+		return infos[a].Name < infos[b].Name
+	})
+
+	return infos
+}
+
+// New returns a new copy of the instrument with the given name.
+func New(name string) (*synth.Synth, error) {
+	m.Lock()
+	i, ok := registry[name]
+	m.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no instrument called %q", name)
+	}
+
+	return i.ctor()
+}
+
+// NewPoly returns a new copy of the instrument with the given name, ready to play several notes at once with the
+// polyphony from its information.
+func NewPoly(name string) (*synth.PolySynth, error) {
+	m.Lock()
+	i, ok := registry[name]
+	m.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no instrument called %q", name)
+	}
+
+	if i.poly != nil {
+		return i.poly()
+	}
+
+	s, err := i.ctor()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := synth.NewPolySynth(s)
+
+	if i.info.Polyphony > 0 {
+		ps.SetPolyphony(i.info.Polyphony)
+	}
+
+	if i.info.Mono {
+		ps.SetMono(s.Mono)
+	}
+
+	return ps, nil
+}
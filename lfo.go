@@ -0,0 +1,164 @@
+package synth
+
+import "math"
+
+// LFOShape is the shape of the wave produced by an LFO.
+type LFOShape int
+
+const (
+	// LFOSine is a sine wave.
+	LFOSine LFOShape = iota
+	// LFOTriangle is a triangle wave.
+	LFOTriangle
+	// LFOSaw is a rising sawtooth wave.
+	LFOSaw
+	// LFOSquare is a square wave.
+	LFOSquare
+	// LFOSampleHold jumps to a new random value at the start of each cycle.
+	LFOSampleHold
+	// LFOSmoothRandom glides smoothly between random values, reaching a new one at the start of each cycle.
+	LFOSmoothRandom
+)
+
+// LFO is a low frequency oscillator, used as a modulation source. Its output is in the range -1 to 1, or 0 to 1
+// if it is unipolar.
+type LFO struct {
+	Shape LFOShape
+
+	// Rate is the speed of the LFO in hertz. It is ignored if Sync is set.
+	Rate float64
+	// Sync, if set, is the length of one cycle of the LFO as a note length at the GlobalTempo.
+	Sync Division
+
+	// Retrigger restarts the LFO from the beginning of its cycle at the start of each note. Otherwise the LFO is
+	// free-running and every note hears the same part of the cycle.
+	Retrigger bool
+	// Phase is where in the cycle the LFO starts, in the range 0 to 1.
+	Phase float64
+
+	// Delay is how long after the start of a note, in seconds, the LFO begins to fade in.
+	Delay float64
+	// Fade is how long the LFO takes to fade in to its full depth, in seconds.
+	Fade float64
+
+	// Unipolar makes the LFO output values in the range 0 to 1 instead of -1 to 1.
+	Unipolar bool
+
+	// cycles is how many cycles the LFO has been through, as of time `last` during the note which started at
+	// `noteOn`. It moves on by the rate each sample rather than being worked out from the time, so that changing the
+	// rate or the tempo doesn't make the LFO jump. It starts afresh the first time the LFO is used, so each voice of a
+	// PolySynth, which gets its own copy of the LFO, keeps track of its own cycles.
+	started      bool
+	cycles, last float64
+	noteOn       float64
+}
+
+// NewLFO returns a new free-running LFO with a rate in hertz.
+func NewLFO(shape LFOShape, rate float64) *LFO {
+	return &LFO{
+		Shape: shape,
+		Rate:  rate,
+	}
+}
+
+// NewSyncedLFO returns a new free-running LFO whose cycle lasts for a note length at the GlobalTempo.
+func NewSyncedLFO(shape LFOShape, sync Division) *LFO {
+	return &LFO{
+		Shape: shape,
+		Sync:  sync,
+	}
+}
+
+// Value returns the value of the LFO for a voice at time `t`.
+func (lfo *LFO) Value(v *Voice, t float64) float64 {
+	seed := 0.0
+	if lfo.Retrigger {
+		seed = v.Random
+	}
+
+	rate := lfo.Rate
+	if lfo.Sync > 0 {
+		rate = 1 / GlobalTempo.Seconds(lfo.Sync)
+	}
+
+	// The LFO starts from the time the first time it is used, if it is streamed from an earlier time, or when it is
+	// retriggered by a new note. A free-running LFO starts where it would be if it had always been at its current
+	// rate, so that every voice hears the same part of the cycle.
+	if !lfo.started || t < lfo.last || (lfo.Retrigger && v.NoteOn != lfo.noteOn) {
+		elapsed := t
+		if lfo.Retrigger {
+			elapsed = t - v.NoteOn
+		}
+
+		lfo.started = true
+		lfo.cycles = elapsed * rate
+	} else {
+		lfo.cycles += (t - lfo.last) * rate
+	}
+	lfo.last, lfo.noteOn = t, v.NoteOn
+
+	val := lfo.shape(lfo.cycles+lfo.Phase, seed)
+	if lfo.Unipolar {
+		val = (val + 1) / 2
+	}
+
+	return val * lfo.fade(t-v.NoteOn)
+}
+
+// shape returns the value of the LFO's wave after a number of cycles.
+func (lfo *LFO) shape(cycles, seed float64) float64 {
+	p := cycles - math.Floor(cycles)
+
+	switch lfo.Shape {
+	case LFOTriangle:
+		q := p + 0.25
+		return 1 - 2*math.Abs(2*(q-math.Floor(q))-1)
+	case LFOSaw:
+		return 2*p - 1
+	case LFOSquare:
+		if p < 0.5 {
+			return 1
+		}
+
+		return -1
+	case LFOSampleHold:
+		return random(math.Floor(cycles), seed)
+	case LFOSmoothRandom:
+		step := math.Floor(cycles)
+		a, b := random(step, seed), random(step+1, seed)
+		s := p * p * (3 - 2*p)
+
+		return a + (b-a)*s
+	}
+
+	return math.Sin(2 * math.Pi * p)
+}
+
+// fade returns how far the LFO has faded in, `elapsed` seconds after the start of a note.
+func (lfo *LFO) fade(elapsed float64) float64 {
+	elapsed -= lfo.Delay
+
+	if elapsed < 0 {
+		return 0
+	}
+
+	if lfo.Fade <= 0 || elapsed >= lfo.Fade {
+		return 1
+	}
+
+	return elapsed / lfo.Fade
+}
+
+// random returns a random value in the range -1 to 1 for a step number. The same step and seed always give the same
+// value, so that random LFOs don't need to remember anything between samples.
+func random(step, seed float64) float64 {
+	x := math.Float64bits(step) ^ math.Float64bits(seed)*0x9E3779B97F4A7C15
+
+	// splitmix64
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	x ^= x >> 31
+
+	return float64(x>>11)/float64(1<<53)*2 - 1
+}
@@ -0,0 +1,36 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+// TestLFOChangeRate checks that changing the rate of an LFO or the tempo it follows mid-note doesn't make it jump.
+func TestLFOChangeRate(t *testing.T) {
+	defer GlobalTempo.SetBPM(GlobalTempo.BPM())
+	GlobalTempo.SetBPM(120)
+
+	free := NewLFO(LFOSine, 2)
+	synced := NewSyncedLFO(LFOSine, Quarter)
+	v := &Voice{NoteOn: 0.3}
+
+	// The largest change between samples of a sine wave at 8Hz, the fastest either LFO goes.
+	limit := 2 * math.Pi * 8 / float64(sr)
+
+	prev := [2]float64{free.Value(v, 0.3), synced.Value(v, 0.3)}
+	for n := 1; n < int(sr); n++ {
+		if n == int(sr)/2 {
+			free.Rate = 8
+			GlobalTempo.SetBPM(300)
+		}
+
+		t0 := 0.3 + float64(n)/float64(sr)
+		for i, x := range [2]float64{free.Value(v, t0), synced.Value(v, t0)} {
+			if math.Abs(x-prev[i]) > limit {
+				t.Fatalf("lfo %d jumped from %v to %v at sample %d", i, prev[i], x, n)
+			}
+
+			prev[i] = x
+		}
+	}
+}
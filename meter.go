@@ -0,0 +1,420 @@
+package synth
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// The ballistics and windows of a Meter.
+const (
+	// meterPeakFall is how many decibels per second the peak level falls by after a peak.
+	meterPeakFall = 20 / 1.7
+	// meterRMSTime is the time constant of the RMS level in seconds.
+	meterRMSTime = 0.3
+
+	// The loudness is measured in blocks of 100ms. The momentary loudness covers the last 4 blocks and the short-term
+	// loudness covers the last 30.
+	loudnessBlockTime = 0.1
+	momentaryBlocks   = 4
+	shortTermBlocks   = 30
+
+	// Gating blocks quieter than the absolute gate are ignored by the integrated loudness, and so are blocks more
+	// than the relative gate below the loudness of the blocks which are left.
+	absoluteGate = -70.0
+	relativeGate = -10.0
+
+	// The gating blocks are kept in a histogram with bins 0.1 LU wide, up to loudnessHistogramTop.
+	loudnessHistogramStep = 0.1
+	loudnessHistogramTop  = 10.0
+)
+
+// loudnessBin is one bin of the histogram of gating block loudnesses.
+type loudnessBin struct {
+	count int
+	power float64
+}
+
+// Meter measures the level of its input without changing it. It measures the sample peak, the RMS level and the
+// true peak in dBFS, and the momentary, short-term and integrated loudness in LUFS as described by EBU R128 and
+// ITU-R BS.1770.
+//
+// A meter can be added to a chain like any other effect, or attached after the fader of a mixer channel using
+// Channel.Meter. Its readings are safe to read from any goroutine while it is streaming, and each sample should only
+// be streamed once, using either Stream or StreamStereo.
+type Meter struct {
+	Input Streamer
+
+	// resetting is set by Reset, and the goroutine streaming the meter clears the rest of its state before measuring
+	// the next sample, so that measuring never waits for a lock.
+	resetting uint32
+
+	peak       [2]float64
+	meanSquare [2]float64
+	kWeighting [2]*kWeighting
+	truePeak   [2]*truePeakDetector
+
+	// blockPower is the sum of the K-weighted power of the current block, and blocks holds the mean power of the most
+	// recent blocks.
+	blockPower   float64
+	blockSamples int
+	blocks       [shortTermBlocks]float64
+	blockCount   int
+	histogram    []loudnessBin
+
+	// The readings are stored as the bits of a float64 so that they can be read atomically.
+	peakReading       [2]uint64
+	maxPeakReading    uint64
+	rmsReading        [2]uint64
+	truePeakReading   uint64
+	momentaryReading  uint64
+	shortTermReading  uint64
+	integratedReading uint64
+}
+
+// NewMeter returns a new meter around a streamer.
+func NewMeter(input Streamer) *Meter {
+	m := &Meter{
+		Input: input,
+	}
+
+	m.reset()
+
+	return m
+}
+
+// SetInput sets the streamer which the meter measures.
+func (m *Meter) SetInput(input Streamer) {
+	m.Input = input
+}
+
+// Stream measures and returns the sample for a given point in time `t`.
+func (m *Meter) Stream(t float64) float64 {
+	x := m.Input.Stream(t)
+	m.process(x, x, false)
+
+	return x
+}
+
+// StreamStereo measures and returns the left and right channels for a given point in time `t`.
+func (m *Meter) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(m.Input, t)
+	m.process(l, r, true)
+
+	return l, r
+}
+
+// process measures a pair of samples. When measuring a mono signal, it only counts once towards the loudness.
+func (m *Meter) process(l, r float64, stereo bool) {
+	if atomic.CompareAndSwapUint32(&m.resetting, 1, 0) {
+		m.reset()
+	}
+
+	fall := DBToGain(-meterPeakFall / float64(sr))
+	average := timeCoefficient(meterRMSTime)
+
+	channels := 1
+	if stereo {
+		channels = 2
+	}
+
+	for c, x := range [2]float64{l, r} {
+		m.peak[c] = math.Max(math.Abs(x), m.peak[c]*fall)
+		m.meanSquare[c] = x*x + average*(m.meanSquare[c]-x*x)
+
+		storeMax(&m.maxPeakReading, GainToDB(x))
+		storeFloat(&m.peakReading[c], GainToDB(m.peak[c]))
+		storeFloat(&m.rmsReading[c], 10*math.Log10(m.meanSquare[c]))
+
+		if c >= channels {
+			continue
+		}
+
+		storeMax(&m.truePeakReading, GainToDB(m.truePeak[c].process(x)))
+
+		weighted := m.kWeighting[c].process(x)
+		m.blockPower += weighted * weighted
+	}
+
+	m.blockSamples++
+	if m.blockSamples >= int(loudnessBlockTime*float64(sr)) {
+		m.endBlock()
+	}
+}
+
+// endBlock finishes measuring the current block, and updates the loudness readings.
+func (m *Meter) endBlock() {
+	copy(m.blocks[1:], m.blocks[:len(m.blocks)-1])
+	m.blocks[0] = m.blockPower / float64(m.blockSamples)
+	m.blockPower, m.blockSamples = 0, 0
+
+	if m.blockCount < len(m.blocks) {
+		m.blockCount++
+	}
+
+	if m.blockCount >= momentaryBlocks {
+		momentary := meanPower(m.blocks[:momentaryBlocks])
+		storeFloat(&m.momentaryReading, powerToLUFS(momentary))
+
+		// Each gating block is as long as the momentary loudness, and they overlap by 75%.
+		if loudness := powerToLUFS(momentary); loudness > absoluteGate {
+			loudness = math.Min(loudness, loudnessHistogramTop-loudnessHistogramStep)
+			i := int((loudness - absoluteGate) / loudnessHistogramStep)
+			m.histogram[i].count++
+			m.histogram[i].power += momentary
+
+			storeFloat(&m.integratedReading, m.integrated())
+		}
+	}
+
+	if m.blockCount >= shortTermBlocks {
+		storeFloat(&m.shortTermReading, powerToLUFS(meanPower(m.blocks[:shortTermBlocks])))
+	}
+}
+
+// integrated works out the integrated loudness from the histogram of gating blocks, which have already been through
+// the absolute gate.
+func (m *Meter) integrated() float64 {
+	count, power := 0, 0.0
+	for _, bin := range m.histogram {
+		count += bin.count
+		power += bin.power
+	}
+
+	if count == 0 {
+		return math.Inf(-1)
+	}
+
+	threshold := powerToLUFS(power/float64(count)) + relativeGate
+
+	count, power = 0, 0.0
+	for i, bin := range m.histogram {
+		if absoluteGate+(float64(i)+0.5)*loudnessHistogramStep >= threshold {
+			count += bin.count
+			power += bin.power
+		}
+	}
+
+	if count == 0 {
+		return math.Inf(-1)
+	}
+
+	return powerToLUFS(power / float64(count))
+}
+
+// reset clears the meter. It must only be called by the goroutine streaming the meter, or before the meter is used.
+func (m *Meter) reset() {
+	for c := range m.kWeighting {
+		m.kWeighting[c] = newKWeighting()
+		m.truePeak[c] = &truePeakDetector{}
+		m.peak[c], m.meanSquare[c] = 0, 0
+	}
+
+	m.blockPower, m.blockSamples, m.blockCount = 0, 0, 0
+	m.histogram = make([]loudnessBin, int((loudnessHistogramTop-absoluteGate)/loudnessHistogramStep))
+
+	m.clearReadings()
+}
+
+// clearReadings sets every reading to silence.
+func (m *Meter) clearReadings() {
+	for _, reading := range []*uint64{
+		&m.peakReading[0], &m.peakReading[1], &m.rmsReading[0], &m.rmsReading[1],
+		&m.maxPeakReading, &m.truePeakReading, &m.momentaryReading, &m.shortTermReading, &m.integratedReading,
+	} {
+		storeFloat(reading, math.Inf(-1))
+	}
+}
+
+// Reset clears the meter's readings, including the maximum peaks and the integrated loudness. The readings are
+// cleared straight away, and the rest of the meter is cleared before it measures the next sample.
+func (m *Meter) Reset() {
+	m.clearReadings()
+	atomic.StoreUint32(&m.resetting, 1)
+}
+
+// Peak returns the peak level of the left and right channels in dBFS. It falls by about 20dB every 1.7 seconds after
+// a peak, like the meter on a mixing desk.
+func (m *Meter) Peak() (l, r float64) {
+	return loadFloat(&m.peakReading[0]), loadFloat(&m.peakReading[1])
+}
+
+// MaxPeak returns the highest sample peak of either channel since the meter was reset, in dBFS. Anything above 0 will
+// clip.
+func (m *Meter) MaxPeak() float64 {
+	return loadFloat(&m.maxPeakReading)
+}
+
+// TruePeak returns the highest true peak of either channel since the meter was reset, in dBTP. This includes peaks in
+// between the samples, which can clip when the signal is converted to analogue or encoded to a lossy format even if
+// none of the samples do.
+func (m *Meter) TruePeak() float64 {
+	return loadFloat(&m.truePeakReading)
+}
+
+// RMS returns the RMS level of the left and right channels in dBFS, averaged over about 300ms.
+func (m *Meter) RMS() (l, r float64) {
+	return loadFloat(&m.rmsReading[0]), loadFloat(&m.rmsReading[1])
+}
+
+// Momentary returns the loudness of the last 400ms in LUFS.
+func (m *Meter) Momentary() float64 {
+	return loadFloat(&m.momentaryReading)
+}
+
+// ShortTerm returns the loudness of the last 3 seconds in LUFS.
+func (m *Meter) ShortTerm() float64 {
+	return loadFloat(&m.shortTermReading)
+}
+
+// Integrated returns the loudness of everything since the meter was reset in LUFS, ignoring silence and quiet
+// passages. This is what streaming services use to normalise their loudness, usually to somewhere around -14 LUFS.
+func (m *Meter) Integrated() float64 {
+	return loadFloat(&m.integratedReading)
+}
+
+// kWeighting is the filter which BS.1770 uses to model how loud different frequencies sound. It is a shelf which
+// models the acoustic effect of the head, followed by a high-pass filter which models how little the ear hears of very
+// low frequencies. The standard only gives coefficients at 48kHz, so they are worked out from the analogue prototypes
+// in the same way as libebur128.
+type kWeighting struct {
+	b, a   [2][3]float64
+	x1, x2 [2]float64
+	y1, y2 [2]float64
+}
+
+// newKWeighting returns a new K-weighting filter for the output sample rate.
+func newKWeighting() *kWeighting {
+	k := &kWeighting{}
+
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	tan := math.Tan(math.Pi * f0 / float64(sr))
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + tan/q + tan*tan
+
+	k.b[0] = [3]float64{(vh + vb*tan/q + tan*tan) / a0, 2 * (tan*tan - vh) / a0, (vh - vb*tan/q + tan*tan) / a0}
+	k.a[0] = [3]float64{1, 2 * (tan*tan - 1) / a0, (1 - tan/q + tan*tan) / a0}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	tan = math.Tan(math.Pi * f0 / float64(sr))
+	a0 = 1 + tan/q + tan*tan
+
+	k.b[1] = [3]float64{1, -2, 1}
+	k.a[1] = [3]float64{1, 2 * (tan*tan - 1) / a0, (1 - tan/q + tan*tan) / a0}
+
+	return k
+}
+
+// process filters a single sample.
+func (k *kWeighting) process(x float64) float64 {
+	for i := range k.b {
+		b, a := k.b[i], k.a[i]
+		y := b[0]*x + b[1]*k.x1[i] + b[2]*k.x2[i] - a[1]*k.y1[i] - a[2]*k.y2[i]
+
+		k.x2[i], k.x1[i] = k.x1[i], x
+		k.y2[i], k.y1[i] = k.y1[i], y
+		x = y
+	}
+
+	return x
+}
+
+// truePeakTaps is the number of samples the true peak detector interpolates between. It is 4 times oversampled, so its
+// filter has 4 times as many taps.
+const truePeakTaps = 16
+
+// truePeakPhases are the coefficients of the true peak detector's interpolation filter, split into one set for each
+// of the 4 points it works out between each pair of samples.
+var truePeakPhases = newTruePeakPhases()
+
+// newTruePeakPhases works out the coefficients of the true peak detector's interpolation filter, which is a
+// windowed sinc filter with its cutoff at the original Nyquist frequency.
+func newTruePeakPhases() [4][truePeakTaps]float64 {
+	var phases [4][truePeakTaps]float64
+
+	n := 4 * truePeakTaps
+	centre := float64(n-1) / 2
+
+	for i := 0; i < n; i++ {
+		x := (float64(i) - centre) / 4
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+
+		// A Blackman window.
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+		phases[i%4][i/4] = sinc * w
+	}
+
+	// Each phase is scaled so that it doesn't change the level of low frequencies.
+	for p := range phases {
+		sum := 0.0
+		for _, c := range phases[p] {
+			sum += c
+		}
+
+		for i := range phases[p] {
+			phases[p][i] /= sum
+		}
+	}
+
+	return phases
+}
+
+// truePeakDetector finds the peaks of a signal in between its samples by interpolating it at 4 times the sample rate.
+type truePeakDetector struct {
+	history [truePeakTaps]float64
+	pos     int
+}
+
+// process adds a sample and returns the highest absolute value of the interpolated signal around it.
+func (d *truePeakDetector) process(x float64) float64 {
+	d.history[d.pos] = x
+	d.pos = (d.pos + 1) % truePeakTaps
+
+	peak := 0.0
+	for _, phase := range truePeakPhases {
+		y := 0.0
+		for i, c := range phase {
+			y += c * d.history[(d.pos+i)%truePeakTaps]
+		}
+
+		peak = math.Max(peak, math.Abs(y))
+	}
+
+	return peak
+}
+
+// meanPower returns the mean of a set of block powers.
+func meanPower(blocks []float64) float64 {
+	sum := 0.0
+	for _, power := range blocks {
+		sum += power
+	}
+
+	return sum / float64(len(blocks))
+}
+
+// powerToLUFS converts the mean power of a K-weighted signal, summed over its channels, into a loudness in LUFS.
+func powerToLUFS(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// storeFloat atomically stores a float64.
+func storeFloat(addr *uint64, x float64) {
+	atomic.StoreUint64(addr, math.Float64bits(x))
+}
+
+// loadFloat atomically loads a float64.
+func loadFloat(addr *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(addr))
+}
+
+// storeMax atomically stores a float64 if it is bigger than the one already stored. It is only called by the
+// goroutine streaming the meter, so it doesn't need to compare and swap.
+func storeMax(addr *uint64, x float64) {
+	if x > loadFloat(addr) {
+		storeFloat(addr, x)
+	}
+}
@@ -0,0 +1,503 @@
+package synth
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// removeMargin is how long after a removed channel should have faded out it is taken out of its mixer, in seconds,
+// to allow for the audio goroutine running slightly behind.
+const removeMargin = 0.05
+
+// Mixer is a streamer which combines the streams from lots of different streamers. Each streamer is added as a
+// channel, which has its own gain, pan, mute, solo and chain of insert effects. The combined output goes through the
+// mixer's output chain.
+//
+// Channels can be added, removed and changed from any goroutine while the mixer is streaming. The audio goroutine
+// never waits for a lock in the mixer, its chains or its meters: the lists of channels and effects are replaced as a
+// whole whenever they change and read atomically, and each channel's settings are stored atomically. Effects guard
+// their own settings, which their setters only hold for a moment.
+//
+// The zero value is an empty mixer ready to use, although NewMixer can add the first streamers straight away.
+type Mixer struct {
+	// once sets the mixer up the first time it is used.
+	once sync.Once
+
+	// m is only held by goroutines changing the list of channels, so that changes made at the same time aren't lost.
+	m        *sync.Mutex
+	channels atomic.Value
+	output   *Chain
+	meter    atomic.Value
+
+	// solos is the number of soloed channels. While any channel is soloed, only soloed channels are heard.
+	solos int32
+}
+
+// mixerBus is the input of a mixer's output chain, which sums the mixer's channels.
+type mixerBus struct {
+	mixer *Mixer
+}
+
+// Stream streams the sum of the mixer's channels.
+func (b *mixerBus) Stream(t float64) float64 {
+	l, _ := b.process(t, false)
+	return l
+}
+
+// StreamStereo streams the sum of the mixer's channels in stereo.
+func (b *mixerBus) StreamStereo(t float64) (l, r float64) {
+	return b.process(t, true)
+}
+
+// process sums the mixer's channels. The channels are streamed first, which fills the inputs of the aux buses
+// through their sends, and then the aux returns are streamed.
+func (b *mixerBus) process(t float64, stereo bool) (l, r float64) {
+	channels := b.mixer.snapshot()
+	soloing := atomic.LoadInt32(&b.mixer.solos) > 0
+
+	for _, c := range channels {
+		if c.aux != nil {
+			c.aux.input.clear()
+		}
+	}
+
+	for _, c := range channels {
+		if c.aux == nil {
+			cl, cr := c.stream(t, stereo, soloing)
+			l += cl
+			r += cr
+		}
+	}
+
+	for _, c := range channels {
+		if c.aux != nil {
+			cl, cr := c.stream(t, stereo, soloing)
+			l += cl
+			r += cr
+		}
+	}
+
+	return l, r
+}
+
+// Stream streams the combination of several streamers.
+func (m *Mixer) Stream(t float64) float64 {
+	m.init()
+
+	x := m.output.Stream(t)
+	if meter := loadMeter(&m.meter); meter != nil {
+		meter.process(x, x, false)
+	}
+
+	return x
+}
+
+// StreamStereo streams the combination of several streamers in stereo.
+func (m *Mixer) StreamStereo(t float64) (l, r float64) {
+	m.init()
+
+	l, r = m.output.StreamStereo(t)
+	if meter := loadMeter(&m.meter); meter != nil {
+		meter.process(l, r, true)
+	}
+
+	return l, r
+}
+
+// snapshot returns the current channels of the mixer.
+func (m *Mixer) snapshot() []*Channel {
+	m.init()
+
+	return m.channels.Load().([]*Channel)
+}
+
+// init sets up the mixer if it hasn't been already, so that the zero value can be used.
+func (m *Mixer) init() {
+	m.once.Do(func() {
+		m.m = &sync.Mutex{}
+		m.channels.Store([]*Channel{})
+		m.output = NewChain(&mixerBus{mixer: m})
+	})
+}
+
+// Add adds one or more streamers to the mixer. Use AddChannel to get the channel of a streamer as it is added.
+func (m *Mixer) Add(streamers ...Streamer) {
+	for _, streamer := range streamers {
+		m.AddChannel(streamer)
+	}
+}
+
+// AddChannel adds a streamer to the mixer and returns its channel, which can be used to change its level or remove
+// it.
+func (m *Mixer) AddChannel(streamer Streamer) *Channel {
+	return m.add(&Channel{chain: NewChain(streamer)})
+}
+
+// add adds a channel to the mixer.
+func (m *Mixer) add(c *Channel) *Channel {
+	c.mixer = m
+	c.sends.Store([]*send{})
+	c.gain = NewParam(1)
+	c.pan = NewParam(0)
+	c.fade = NewParam(1)
+
+	m.init()
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	// The channels are copied rather than appended to in place, so that a snapshot being streamed isn't changed.
+	old := m.snapshot()
+	channels := make([]*Channel, len(old), len(old)+1)
+	copy(channels, old)
+	m.channels.Store(append(channels, c))
+
+	return c
+}
+
+// remove removes a channel from the mixer. It must be called with the lock held.
+func (m *Mixer) remove(c *Channel) {
+	old := m.snapshot()
+	channels := make([]*Channel, 0, len(old))
+	for _, other := range old {
+		if other != c {
+			channels = append(channels, other)
+		}
+	}
+
+	m.channels.Store(channels)
+}
+
+// Channels returns the channels of the mixer. Channels which have been removed aren't included, even while they are
+// fading out.
+func (m *Mixer) Channels() []*Channel {
+	var channels []*Channel
+	for _, c := range m.snapshot() {
+		if !c.isRemoved() {
+			channels = append(channels, c)
+		}
+	}
+
+	return channels
+}
+
+// Channel returns the channel of a streamer which has been added to the mixer, or nil if it hasn't been added.
+func (m *Mixer) Channel(streamer Streamer) *Channel {
+	for _, c := range m.snapshot() {
+		if c.aux == nil && !c.isRemoved() && sameStreamer(c.chain.Input, streamer) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Inserts returns the chain of insert effects for a streamer which has been added to the mixer, or nil if it hasn't
+// been added.
+func (m *Mixer) Inserts(streamer Streamer) *Chain {
+	if c := m.Channel(streamer); c != nil {
+		return c.chain
+	}
+
+	return nil
+}
+
+// sameStreamer returns true if two streamers are the same. Streamers which can't be compared, such as
+// StreamerFuncs, are never the same.
+func sameStreamer(a, b Streamer) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// Meter returns a meter which measures the output of the mixer, after its output chain. The meter is created the first
+// time this is called.
+func (m *Mixer) Meter() *Meter {
+	m.init()
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return attachMeter(&m.meter)
+}
+
+// Output returns the chain of effects which the combined output of the mixer goes through.
+func (m *Mixer) Output() *Chain {
+	m.init()
+
+	return m.output
+}
+
+// AddGroup adds a new mixer to the mixer as a group bus and returns it along with its channel. Streamers added to the
+// group are mixed together and go through the group's output chain before reaching this mixer, so a set of
+// instruments can share a level, pan and effects.
+func (m *Mixer) AddGroup() (*Mixer, *Channel) {
+	group := NewMixer()
+	return group, m.AddChannel(group)
+}
+
+// NewMixer returns a new mixer for a set of streamers.
+func NewMixer(streamers ...Streamer) *Mixer {
+	m := &Mixer{}
+	m.init()
+
+	m.Add(streamers...)
+
+	return m
+}
+
+// Channel is a streamer which has been added to a Mixer. Its settings are safe to change while the mixer is playing.
+type Channel struct {
+	mixer *Mixer
+	chain *Chain
+
+	meter atomic.Value
+
+	// aux is the aux bus whose return this channel is, if any.
+	aux *Aux
+	// sends is the list of sends from the channel to aux buses. It is replaced as a whole whenever it changes.
+	sends atomic.Value
+
+	// gain is the linear gain of the channel. It and the pan ramp to new values so that they don't click.
+	gain *Param
+	pan  *Param
+
+	muted uint32
+	solo  uint32
+
+	// fade fades the channel in and out when it is muted, unmuted or soloed. heard is whether it is fading in, and
+	// started is whether it has been streamed yet. Both are only used by the goroutine streaming the mixer.
+	fade    *Param
+	heard   bool
+	started bool
+
+	// removed is only changed with the mixer's lock held, so that it is consistent with the mixer's solo count. It is
+	// stored atomically since the audio goroutine fades the channel out once it has been removed.
+	removed uint32
+}
+
+// audible returns true if the channel should be heard, given whether any channel in the mixer is soloed. Aux returns
+// are still heard when other channels are soloed, so that the soloed channels keep their reverb and delay.
+func (c *Channel) audible(soloing bool) bool {
+	if atomic.LoadUint32(&c.muted) == 1 || c.isRemoved() {
+		return false
+	}
+
+	return !soloing || c.aux != nil || atomic.LoadUint32(&c.solo) == 1
+}
+
+// stream returns the channel at time `t` after the gain and pan, and feeds its sends and meter. When streaming in
+// mono, the pan is ignored and both channels are the same. A channel which can't be heard isn't streamed at all once
+// it has faded out.
+func (c *Channel) stream(t float64, stereo, soloing bool) (l, r float64) {
+	meter := loadMeter(&c.meter)
+
+	if heard := c.audible(soloing); !c.started {
+		c.started, c.heard = true, heard
+		c.fade.Jump(boolToFloat(heard))
+	} else if heard != c.heard {
+		c.heard = heard
+		c.fade.SetAt(t, boolToFloat(heard))
+	}
+
+	fade := c.fade.At(t)
+	if fade == 0 {
+		if meter != nil {
+			meter.process(0, 0, stereo)
+		}
+
+		return 0, 0
+	}
+
+	var preL, preR float64
+	if stereo {
+		preL, preR = c.chain.StreamStereo(t)
+	} else {
+		preL = c.chain.Stream(t)
+		preR = preL
+	}
+
+	preL, preR = preL*fade, preR*fade
+
+	g := c.gain.At(t)
+	l, r = preL*g, preR*g
+
+	if stereo {
+		gl, gr := panGains(c.pan.At(t))
+		l, r = l*gl, r*gr
+	}
+
+	for _, s := range c.snapshotSends() {
+		g := s.level.At(t)
+
+		if s.isPre() {
+			s.aux.input.add(preL*g, preR*g)
+		} else {
+			s.aux.input.add(l*g, r*g)
+		}
+	}
+
+	if meter != nil {
+		meter.process(l, r, stereo)
+	}
+
+	return l, r
+}
+
+// Streamer returns the streamer which was added to the mixer.
+func (c *Channel) Streamer() Streamer {
+	return c.chain.Input
+}
+
+// Inserts returns the chain of insert effects which the streamer goes through before its gain and pan.
+func (c *Channel) Inserts() *Chain {
+	return c.chain
+}
+
+// Meter returns a meter which measures the channel after its gain and pan. The meter is created the first time this
+// is called.
+func (c *Channel) Meter() *Meter {
+	c.mixer.m.Lock()
+	defer c.mixer.m.Unlock()
+
+	return attachMeter(&c.meter)
+}
+
+// SetGain sets the gain of the channel in decibels, where 0 leaves the level unchanged.
+func (c *Channel) SetGain(db float64) {
+	c.gain.Set(DBToGain(db))
+}
+
+// Gain returns the gain of the channel in decibels.
+func (c *Channel) Gain() float64 {
+	return GainToDB(c.gain.Target())
+}
+
+// SetPan sets the position of the channel in the stereo field, where -1 is hard left and 1 is hard right. For stereo
+// streamers it balances the two channels.
+func (c *Channel) SetPan(pan float64) {
+	c.pan.Set(math.Max(-1, math.Min(1, pan)))
+}
+
+// Pan returns the position of the channel in the stereo field.
+func (c *Channel) Pan() float64 {
+	return c.pan.Target()
+}
+
+// SetRamp sets how long the channel takes to reach a new gain or pan, or to fade in or out when it is muted, soloed or
+// removed, in seconds.
+func (c *Channel) SetRamp(time float64) {
+	c.gain.SetRamp(time, LinearRamp)
+	c.pan.SetRamp(time, LinearRamp)
+	c.fade.SetRamp(time, LinearRamp)
+}
+
+// SetMute sets whether the channel is muted.
+func (c *Channel) SetMute(muted bool) {
+	atomic.StoreUint32(&c.muted, boolToUint32(muted))
+}
+
+// Muted returns true if the channel is muted.
+func (c *Channel) Muted() bool {
+	return atomic.LoadUint32(&c.muted) == 1
+}
+
+// SetSolo sets whether the channel is soloed. While any channel in the mixer is soloed, only soloed channels are
+// heard.
+func (c *Channel) SetSolo(solo bool) {
+	c.mixer.m.Lock()
+	defer c.mixer.m.Unlock()
+
+	v := boolToUint32(solo)
+
+	if old := atomic.SwapUint32(&c.solo, v); old != v && !c.isRemoved() {
+		if solo {
+			atomic.AddInt32(&c.mixer.solos, 1)
+		} else {
+			atomic.AddInt32(&c.mixer.solos, -1)
+		}
+	}
+}
+
+// Soloed returns true if the channel is soloed.
+func (c *Channel) Soloed() bool {
+	return atomic.LoadUint32(&c.solo) == 1
+}
+
+// Remove removes the channel from the mixer, so the streamer is no longer played. The channel fades out first so that
+// it doesn't click, and is taken out of the mixer once it has faded.
+func (c *Channel) Remove() {
+	c.mixer.m.Lock()
+	defer c.mixer.m.Unlock()
+
+	if c.isRemoved() {
+		return
+	}
+	atomic.StoreUint32(&c.removed, 1)
+
+	if atomic.LoadUint32(&c.solo) == 1 {
+		atomic.AddInt32(&c.mixer.solos, -1)
+	}
+
+	fade, _ := c.fade.Ramp()
+	time.AfterFunc(time.Duration((fade+removeMargin)*float64(time.Second)), c.detach)
+
+	// Nothing can send to an aux bus once its return has been removed.
+	if c.aux != nil {
+		for _, other := range c.mixer.snapshot() {
+			other.removeSend(c.aux)
+		}
+	}
+}
+
+// isRemoved returns true if the channel has been removed, even if it is still fading out.
+func (c *Channel) isRemoved() bool {
+	return atomic.LoadUint32(&c.removed) == 1
+}
+
+// detach takes a removed channel out of its mixer once it has faded out.
+func (c *Channel) detach() {
+	c.mixer.m.Lock()
+	c.mixer.remove(c)
+	c.mixer.m.Unlock()
+}
+
+// boolToFloat converts a bool to 1 or 0.
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// boolToUint32 converts a bool to 1 or 0, so that it can be stored atomically.
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// loadMeter returns the meter attached to a channel or mixer, or nil if there isn't one.
+func loadMeter(v *atomic.Value) *Meter {
+	meter, _ := v.Load().(*Meter)
+	return meter
+}
+
+// attachMeter returns the meter attached to a channel or mixer, creating it if there isn't one. It must be called with
+// the mixer's lock held.
+func attachMeter(v *atomic.Value) *Meter {
+	if meter := loadMeter(v); meter != nil {
+		return meter
+	}
+
+	meter := NewMeter(nil)
+	v.Store(meter)
+
+	return meter
+}
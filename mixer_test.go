@@ -0,0 +1,161 @@
+package synth
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// constant is a streamer which always returns the same sample.
+type constant float64
+
+func (c constant) Stream(t float64) float64 {
+	return float64(c)
+}
+
+// TestMixerConcurrentChanges changes a mixer from several goroutines while it streams. It is meant to be run with the
+// race detector.
+func TestMixerConcurrentChanges(t *testing.T) {
+	m := NewMixer()
+	aux := m.AddAux(NewDelay(nil, 10, 0.3, 1))
+	group, _ := m.AddGroup()
+	group.Add(constant(0.1), constant(0.2))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			m.StreamStereo(float64(n) / float64(sr))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				c := m.AddChannel(constant(0.5))
+				c.SetGain(-6)
+				c.SetPan(float64(g%3) - 1)
+				c.SetSend(aux, -12)
+				c.SetSendPre(aux, i%2 == 0)
+				c.SetSolo(i%3 == 0)
+				c.SetMute(i%5 == 0)
+				c.Meter().Peak()
+
+				delay := NewDelay(nil, 5, 0, 0.5)
+				c.Inserts().Add(delay)
+				c.Inserts().SetBypass(delay, i%2 == 0)
+				c.Inserts().Move(delay, 0)
+
+				if i%2 == 0 {
+					c.RemoveSend(aux)
+					c.Inserts().Remove(delay)
+				}
+
+				c.Remove()
+				m.Channels()
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(stop)
+	<-done
+}
+
+// TestChannelRemoveFadesOut checks that removing a channel fades it out rather than cutting it off, and then takes it
+// out of the mixer.
+func TestChannelRemoveFadesOut(t *testing.T) {
+	m := NewMixer()
+	c := m.AddChannel(constant(1))
+
+	if x := m.Stream(0); x != 1 {
+		t.Fatalf("expected 1 before removing, got %v", x)
+	}
+
+	c.Remove()
+
+	prev := 1.0
+	for n := 1; n < int(2*DefaultRampTime*float64(sr)); n++ {
+		x := m.Stream(float64(n) / float64(sr))
+		if x > prev || prev-x > 2/(DefaultRampTime*float64(sr)) {
+			t.Fatalf("sample %d jumped from %v to %v", n, prev, x)
+		}
+
+		prev = x
+	}
+
+	if prev != 0 {
+		t.Fatalf("expected the channel to have faded out, got %v", prev)
+	}
+
+	if len(m.Channels()) != 0 {
+		t.Fatalf("expected a removed channel not to be listed")
+	}
+
+	time.Sleep(time.Duration((DefaultRampTime + 2*removeMargin) * float64(time.Second)))
+
+	m.m.Lock()
+	left := len(m.snapshot())
+	m.m.Unlock()
+
+	if left != 0 {
+		t.Fatalf("expected the channel to have been taken out of the mixer, %d left", left)
+	}
+}
+
+// TestRemoveSoloedChannel checks that removing a soloed channel lets the other channels be heard again.
+func TestRemoveSoloedChannel(t *testing.T) {
+	m := NewMixer()
+	m.AddChannel(constant(1))
+
+	soloed := m.AddChannel(constant(2))
+	soloed.SetSolo(true)
+	soloed.SetSolo(true)
+
+	if x := m.Stream(0); x != 2 {
+		t.Fatalf("expected only the soloed channel, got %v", x)
+	}
+
+	soloed.Remove()
+	soloed.SetSolo(false)
+
+	// The other channel fades in from when the mixer notices the solo has gone.
+	start := 1 / float64(sr)
+	m.Stream(start)
+
+	if x := m.Stream(start + 2*DefaultRampTime); math.Abs(x-1) > 1e-9 {
+		t.Fatalf("expected the other channel once the soloed one was removed, got %v", x)
+	}
+}
+
+// TestZeroMixer checks that a zero-value mixer can be used without NewMixer.
+func TestZeroMixer(t *testing.T) {
+	m := &Mixer{}
+
+	if x := m.Stream(0); x != 0 {
+		t.Fatalf("expected an empty mixer to be silent, got %v", x)
+	}
+
+	m.Add(constant(1))
+	m.Output()
+	m.Meter()
+
+	if x := m.Stream(1 / float64(sr)); x != 1 {
+		t.Fatalf("expected 1 from the added streamer, got %v", x)
+	}
+}
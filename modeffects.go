@@ -0,0 +1,412 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// The delays used by the chorus and flanger, in milliseconds.
+const (
+	chorusDelay  = 15.0
+	flangerDelay = 1.0
+	maxModDelay  = 50.0
+)
+
+// advancePhase moves the phase of an effect's LFO on by one sample at a rate in hertz, keeping it in the range 0 to 1.
+// The LFOs keep their own phase rather than working it out from the time, so that changing the rate doesn't make them
+// jump to a different point in the cycle.
+func advancePhase(phase, rate float64) float64 {
+	phase += rate / float64(sr)
+	return phase - math.Floor(phase)
+}
+
+// Chorus is an effect which thickens its input by mixing in several copies of it, each delayed by a slowly wobbling
+// amount so that they drift slightly out of tune. The left and right channels wobble out of step with each other,
+// which makes the sound wider.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Chorus struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	voices   int
+	rate     float64
+	phase    float64
+	depth    *Param
+	feedback *Param
+	mix      *Param
+
+	lines [2]*delayLine
+	wet   [2]float64
+}
+
+// NewChorus returns a new chorus around a streamer. The rate is how fast the delays wobble in hertz, the depth is how
+// far they wobble in milliseconds, and the mix is how much of the effect is heard, from 0 to 1.
+func NewChorus(input Streamer, voices int, rate, depth, mix float64) *Chorus {
+	if voices < 1 {
+		voices = 1
+	}
+
+	length := int(maxModDelay / 1000 * float64(sr))
+
+	return &Chorus{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		voices:   voices,
+		rate:     rate,
+		depth:    NewParam(clampChorusDepth(depth)),
+		feedback: NewParam(0),
+		mix:      NewParam(mix),
+
+		lines: [2]*delayLine{newDelayLine(length), newDelayLine(length)},
+	}
+}
+
+// SetInput sets the streamer which the chorus processes.
+func (c *Chorus) SetInput(input Streamer) {
+	c.Input = input
+}
+
+// Stream returns the sample for a given point in time `t`.
+func (c *Chorus) Stream(t float64) float64 {
+	x := c.Input.Stream(t)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	y := c.process(0, x, t)
+	c.phase = advancePhase(c.phase, c.rate)
+
+	return y
+}
+
+// StreamStereo returns the left and right channels for a given point in time `t`.
+func (c *Chorus) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(c.Input, t)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	l, r = c.process(0, l, t), c.process(1, r, t)
+	c.phase = advancePhase(c.phase, c.rate)
+
+	return l, r
+}
+
+// process processes a sample from one channel. It must be called with the lock held.
+func (c *Chorus) process(channel int, x, t float64) float64 {
+	line := c.lines[channel]
+	depth := c.depth.At(t)
+	wet := 0.0
+
+	for i := 0; i < c.voices; i++ {
+		// The voices are spread evenly around the cycle, and the right channel is a quarter of a cycle behind.
+		phase := float64(i)/float64(c.voices) + float64(channel)/4
+		lfo := math.Sin(2 * math.Pi * (c.phase + phase))
+
+		delay := (chorusDelay + depth*(lfo+1)/2) / 1000 * float64(sr)
+		wet += line.read(delay)
+	}
+	wet /= float64(c.voices)
+
+	line.write(x + c.feedback.At(t)*c.wet[channel])
+	c.wet[channel] = wet
+
+	mix := c.mix.At(t)
+	return x*(1-mix) + wet*mix
+}
+
+// SetVoices sets the number of delayed copies which are mixed in.
+func (c *Chorus) SetVoices(voices int) {
+	if voices < 1 {
+		voices = 1
+	}
+
+	c.m.Lock()
+	c.voices = voices
+	c.m.Unlock()
+}
+
+// SetRate sets how fast the delays wobble, in hertz.
+func (c *Chorus) SetRate(rate float64) {
+	c.m.Lock()
+	c.rate = rate
+	c.m.Unlock()
+}
+
+// SetDepth sets how far the delays wobble, in milliseconds.
+func (c *Chorus) SetDepth(depth float64) {
+	c.depth.Set(clampChorusDepth(depth))
+}
+
+// clampChorusDepth limits the depth of a chorus so that its delays stay within the delay lines.
+func clampChorusDepth(depth float64) float64 {
+	return math.Max(0, math.Min(maxModDelay-chorusDelay, depth))
+}
+
+// SetFeedback sets how much of the output is fed back into the delays.
+func (c *Chorus) SetFeedback(feedback float64) {
+	c.feedback.Set(clampFeedback(feedback))
+}
+
+// SetMix sets how much of the effect is heard, where 0 is only the input and 1 is only the delayed copies.
+func (c *Chorus) SetMix(mix float64) {
+	c.mix.Set(mix)
+}
+
+// Flanger is an effect which mixes its input with a copy delayed by a very short, sweeping amount. Feeding the copy
+// back into itself gives the classic jet-plane whoosh.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Flanger struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	rate     float64
+	phase    float64
+	depth    *Param
+	feedback *Param
+	mix      *Param
+
+	lines [2]*delayLine
+}
+
+// NewFlanger returns a new flanger around a streamer. The rate is how fast the delay sweeps in hertz, the depth is
+// how far it sweeps in milliseconds, and the mix is how much of the effect is heard, from 0 to 1.
+func NewFlanger(input Streamer, rate, depth, feedback, mix float64) *Flanger {
+	length := int(maxModDelay / 1000 * float64(sr))
+
+	return &Flanger{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		rate:     rate,
+		depth:    NewParam(clampFlangerDepth(depth)),
+		feedback: NewParam(clampFeedback(feedback)),
+		mix:      NewParam(mix),
+
+		lines: [2]*delayLine{newDelayLine(length), newDelayLine(length)},
+	}
+}
+
+// SetInput sets the streamer which the flanger processes.
+func (f *Flanger) SetInput(input Streamer) {
+	f.Input = input
+}
+
+// Stream returns the sample for a given point in time `t`.
+func (f *Flanger) Stream(t float64) float64 {
+	x := f.Input.Stream(t)
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	y := f.process(0, x, t)
+	f.phase = advancePhase(f.phase, f.rate)
+
+	return y
+}
+
+// StreamStereo returns the left and right channels for a given point in time `t`.
+func (f *Flanger) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(f.Input, t)
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	l, r = f.process(0, l, t), f.process(1, r, t)
+	f.phase = advancePhase(f.phase, f.rate)
+
+	return l, r
+}
+
+// process processes a sample from one channel. It must be called with the lock held.
+func (f *Flanger) process(channel int, x, t float64) float64 {
+	lfo := math.Sin(2 * math.Pi * (f.phase + float64(channel)/4))
+	delay := (flangerDelay + f.depth.At(t)*(lfo+1)/2) / 1000 * float64(sr)
+
+	wet := f.lines[channel].read(delay)
+	f.lines[channel].write(x + f.feedback.At(t)*wet)
+
+	mix := f.mix.At(t)
+	return x*(1-mix) + wet*mix
+}
+
+// SetRate sets how fast the delay sweeps, in hertz.
+func (f *Flanger) SetRate(rate float64) {
+	f.m.Lock()
+	f.rate = rate
+	f.m.Unlock()
+}
+
+// SetDepth sets how far the delay sweeps, in milliseconds.
+func (f *Flanger) SetDepth(depth float64) {
+	f.depth.Set(clampFlangerDepth(depth))
+}
+
+// clampFlangerDepth limits the depth of a flanger so that its delay stays within the delay lines.
+func clampFlangerDepth(depth float64) float64 {
+	return math.Max(0, math.Min(maxModDelay-flangerDelay, depth))
+}
+
+// SetFeedback sets how much of the delayed copy is fed back into the delay. Negative feedback gives a hollower
+// sound.
+func (f *Flanger) SetFeedback(feedback float64) {
+	f.feedback.Set(clampFeedback(feedback))
+}
+
+// SetMix sets how much of the effect is heard, where 0 is only the input and 1 is only the delayed copy.
+func (f *Flanger) SetMix(mix float64) {
+	f.mix.Set(mix)
+}
+
+// The range of frequencies swept by a phaser, in hertz.
+const (
+	phaserMinFreq = 100.0
+	phaserMaxFreq = 4000.0
+)
+
+// Phaser is an effect which passes its input through a chain of all-pass filters whose frequency sweeps up and down.
+// Mixing the result with the input cancels out a set of moving notches in the spectrum.
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Phaser struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	stages   int
+	rate     float64
+	phase    float64
+	depth    *Param
+	feedback *Param
+	mix      *Param
+
+	// The previous input and output of each all-pass stage, for each channel.
+	x1, y1 [2][]float64
+	wet    [2]float64
+}
+
+// NewPhaser returns a new phaser around a streamer. The number of stages sets how many notches there are, the rate
+// is how fast they sweep in hertz, the depth is how much of the frequency range they sweep over, from 0 to 1, and
+// the mix is how much of the effect is heard, from 0 to 1. A mix of 0.5 gives the deepest notches.
+func NewPhaser(input Streamer, stages int, rate, depth, feedback, mix float64) *Phaser {
+	p := &Phaser{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		rate:     rate,
+		depth:    NewParam(math.Max(0, math.Min(1, depth))),
+		feedback: NewParam(clampFeedback(feedback)),
+		mix:      NewParam(mix),
+	}
+
+	p.setStages(stages)
+
+	return p
+}
+
+// SetInput sets the streamer which the phaser processes.
+func (p *Phaser) SetInput(input Streamer) {
+	p.Input = input
+}
+
+// Stream returns the sample for a given point in time `t`.
+func (p *Phaser) Stream(t float64) float64 {
+	x := p.Input.Stream(t)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	y := p.process(0, x, t)
+	p.phase = advancePhase(p.phase, p.rate)
+
+	return y
+}
+
+// StreamStereo returns the left and right channels for a given point in time `t`.
+func (p *Phaser) StreamStereo(t float64) (l, r float64) {
+	l, r = StreamStereo(p.Input, t)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	l, r = p.process(0, l, t), p.process(1, r, t)
+	p.phase = advancePhase(p.phase, p.rate)
+
+	return l, r
+}
+
+// process processes a sample from one channel. It must be called with the lock held.
+func (p *Phaser) process(channel int, x, t float64) float64 {
+	lfo := (math.Sin(2*math.Pi*(p.phase+float64(channel)/4)) + 1) / 2
+
+	// The frequency sweeps exponentially so that it moves evenly through the octaves.
+	octaves := math.Log2(phaserMaxFreq / phaserMinFreq)
+	freq := phaserMinFreq * math.Pow(2, octaves*(1-p.depth.At(t)*(1-lfo)))
+
+	w := math.Tan(math.Pi * clampCutoff(freq) / float64(sr))
+	a := (w - 1) / (w + 1)
+
+	y := x + p.feedback.At(t)*p.wet[channel]
+	for i := 0; i < p.stages; i++ {
+		out := a*y + p.x1[channel][i] - a*p.y1[channel][i]
+
+		p.x1[channel][i] = y
+		p.y1[channel][i] = out
+		y = out
+	}
+	p.wet[channel] = y
+
+	mix := p.mix.At(t)
+	return x*(1-mix) + y*mix
+}
+
+// setStages sets the number of all-pass stages. It must be called with the lock held.
+func (p *Phaser) setStages(stages int) {
+	if stages < 1 {
+		stages = 1
+	}
+
+	p.stages = stages
+
+	for channel := range p.x1 {
+		p.x1[channel] = make([]float64, stages)
+		p.y1[channel] = make([]float64, stages)
+	}
+}
+
+// SetStages sets the number of all-pass stages. Each pair of stages adds another notch.
+func (p *Phaser) SetStages(stages int) {
+	p.m.Lock()
+	p.setStages(stages)
+	p.m.Unlock()
+}
+
+// SetRate sets how fast the notches sweep, in hertz.
+func (p *Phaser) SetRate(rate float64) {
+	p.m.Lock()
+	p.rate = rate
+	p.m.Unlock()
+}
+
+// SetDepth sets how much of the frequency range the notches sweep over, from 0 to 1.
+func (p *Phaser) SetDepth(depth float64) {
+	p.depth.Set(math.Max(0, math.Min(1, depth)))
+}
+
+// SetFeedback sets how much of the output of the filters is fed back into them, which makes the notches sharper.
+func (p *Phaser) SetFeedback(feedback float64) {
+	p.feedback.Set(clampFeedback(feedback))
+}
+
+// SetMix sets how much of the effect is heard, where 0 is only the input and 1 is only the filtered signal.
+func (p *Phaser) SetMix(mix float64) {
+	p.mix.Set(mix)
+}
@@ -0,0 +1,222 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Destinations which are understood by every Synth. Any other name can be used as a destination too, and read by
+// the stream function of a synth created with NewVoiceSynth using Voice.Mod.
+const (
+	// DestPitch shifts the pitch of the note, in semitones.
+	DestPitch = "pitch"
+	// DestAmp is added to the amplitude of the note, which is normally 1.
+	DestAmp = "amp"
+	// DestCutoff shifts the cutoff of the synth's filter, in octaves.
+	DestCutoff = "cutoff"
+	// DestPan is added to the pan of the note, where -1 is hard left and 1 is hard right.
+	DestPan = "pan"
+	// DestPulseWidth is added to the width of pulse oscillators. It is up to the stream function to read it.
+	DestPulseWidth = "pulsewidth"
+)
+
+// ControllerModWheel is the MIDI controller number of the mod wheel.
+const ControllerModWheel = 1
+
+// Voice describes the note a synth is currently playing. It is passed to modulation sources and to the stream
+// functions of synths created with NewVoiceSynth.
+type Voice struct {
+	// Amp is the current level of the envelope.
+	Amp float64
+	// Freq is the frequency to play at. The time passed along with it is adjusted so that changes in pitch, such as
+	// glides and pitch modulation, are heard without the phase jumping.
+	Freq float64
+
+	// Velocity is the velocity of the note, in the range 0 to 1.
+	Velocity float64
+	// Key is the current pitch of the note as a MIDI note number, which may be fractional.
+	Key float64
+	// NoteOn is the time the note started.
+	NoteOn float64
+	// Random is a random value in the range -1 to 1 which is chosen when the note starts.
+	Random float64
+	// Aftertouch is the pressure on this particular note, in the range 0 to 1.
+	Aftertouch float64
+
+	controls *Controls
+	mods     map[string]float64
+}
+
+// Mod returns the total modulation routed to a destination for the current sample.
+func (v *Voice) Mod(dest string) float64 {
+	return v.mods[dest]
+}
+
+// Controller returns the value of a MIDI controller, in the range 0 to 1.
+func (v *Voice) Controller(cc int) float64 {
+	if v.controls == nil {
+		return 0
+	}
+
+	return v.controls.Get(cc)
+}
+
+// Controls holds the values of the MIDI controllers and channel aftertouch for a synth, in the range 0 to 1. They
+// are shared by every voice of a PolySynth and are safe to set from any goroutine.
+type Controls struct {
+	cc         [128]uint64
+	aftertouch uint64
+}
+
+// Set sets the value of a MIDI controller.
+func (c *Controls) Set(cc int, value float64) {
+	if cc < 0 || cc >= len(c.cc) {
+		return
+	}
+
+	atomic.StoreUint64(&c.cc[cc], math.Float64bits(value))
+}
+
+// Get returns the value of a MIDI controller.
+func (c *Controls) Get(cc int) float64 {
+	if cc < 0 || cc >= len(c.cc) {
+		return 0
+	}
+
+	return math.Float64frombits(atomic.LoadUint64(&c.cc[cc]))
+}
+
+// SetAftertouch sets the channel aftertouch.
+func (c *Controls) SetAftertouch(value float64) {
+	atomic.StoreUint64(&c.aftertouch, math.Float64bits(value))
+}
+
+// Aftertouch returns the channel aftertouch.
+func (c *Controls) Aftertouch() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.aftertouch))
+}
+
+// ModSource is anything which can modulate a parameter. Given the voice being modulated and a time `t`, it returns a
+// value which is usually in the range -1 to 1 for bipolar sources or 0 to 1 for unipolar ones.
+type ModSource interface {
+	Value(v *Voice, t float64) float64
+}
+
+// triggeredSource is a modulation source which needs to know when each note starts and stops, such as an envelope.
+type triggeredSource interface {
+	Attack(t float64)
+	Release(t float64)
+}
+
+// ModSourceFunc is a modulation source made of a function.
+type ModSourceFunc func(v *Voice, t float64) float64
+
+// Value returns the value of the modulation source function.
+func (f ModSourceFunc) Value(v *Voice, t float64) float64 {
+	return f(v, t)
+}
+
+var (
+	// VelocitySource is the velocity of the note, in the range 0 to 1.
+	VelocitySource = ModSourceFunc(func(v *Voice, t float64) float64 {
+		return v.Velocity
+	})
+
+	// KeySource is the pitch of the note in octaves relative to middle C, so routing it to DestCutoff with a depth
+	// of 1 makes the cutoff follow the keyboard.
+	KeySource = ModSourceFunc(func(v *Voice, t float64) float64 {
+		return (v.Key - 60) / 12
+	})
+
+	// RandomSource is a random value in the range -1 to 1 which changes with each note.
+	RandomSource = ModSourceFunc(func(v *Voice, t float64) float64 {
+		return v.Random
+	})
+
+	// ModWheelSource is the position of the mod wheel, in the range 0 to 1.
+	ModWheelSource = ControllerSource(ControllerModWheel)
+
+	// AftertouchSource is the pressure on the note, in the range 0 to 1. It uses whichever is higher out of the
+	// channel aftertouch and the note's own aftertouch.
+	AftertouchSource = ModSourceFunc(func(v *Voice, t float64) float64 {
+		if v.controls == nil {
+			return v.Aftertouch
+		}
+
+		return math.Max(v.Aftertouch, v.controls.Aftertouch())
+	})
+)
+
+// ControllerSource returns a modulation source which follows a MIDI controller, in the range 0 to 1.
+func ControllerSource(cc int) ModSource {
+	return ModSourceFunc(func(v *Voice, t float64) float64 {
+		return v.Controller(cc)
+	})
+}
+
+// OscillatorSource uses an oscillator, such as a slow sine wave, as a free-running modulation source.
+type OscillatorSource struct {
+	Osc Oscillator
+}
+
+// Value returns the value of the oscillator at time `t`.
+func (s *OscillatorSource) Value(v *Voice, t float64) float64 {
+	return s.Osc.Stream(t)
+}
+
+// EnvSource uses an envelope as a modulation source. It is triggered at the start and end of each note, and each
+// voice of a PolySynth gets its own copy.
+type EnvSource struct {
+	Env Envelope
+}
+
+// Value returns the level of the envelope at time `t`.
+func (s *EnvSource) Value(v *Voice, t float64) float64 {
+	return s.Env.GetAmplitude(t)
+}
+
+// Attack triggers the attack phase of the envelope.
+func (s *EnvSource) Attack(t float64) {
+	s.Env.Attack(t)
+}
+
+// Release triggers the release phase of the envelope.
+func (s *EnvSource) Release(t float64) {
+	s.Env.Release(t)
+}
+
+// ModRoute connects a modulation source to a named destination. The value of the source is multiplied by the depth
+// before it is added to the destination.
+type ModRoute struct {
+	Source ModSource
+	Dest   string
+	Depth  float64
+}
+
+// start resets the voice at the start of a new note at time `t`.
+func (v *Voice) start(t, velocity float64) {
+	v.Velocity = velocity
+	v.NoteOn = t
+	v.Random = 2*rand.Float64() - 1
+	v.Aftertouch = 0
+}
+
+// modulate works out the total modulation for each destination at time `t`.
+func (v *Voice) modulate(routes []ModRoute, t float64) {
+	if v.mods == nil {
+		v.mods = map[string]float64{}
+	}
+
+	for dest := range v.mods {
+		v.mods[dest] = 0
+	}
+
+	for _, route := range routes {
+		if route.Source == nil {
+			continue
+		}
+
+		v.mods[route.Dest] += route.Depth * route.Source.Value(v, t)
+	}
+}
@@ -0,0 +1,228 @@
+package synth
+
+import "math"
+
+// NotePriority decides which held note a monophonic synth plays when several keys are held at once.
+type NotePriority int
+
+const (
+	// PriorityLast plays the most recently pressed note.
+	PriorityLast NotePriority = iota
+	// PriorityLow plays the lowest held note.
+	PriorityLow
+	// PriorityHigh plays the highest held note.
+	PriorityHigh
+)
+
+// GlideCurve is the shape of a portamento glide between two notes.
+type GlideCurve int
+
+const (
+	// GlideLinear moves through the pitches between the notes at a constant rate.
+	GlideLinear GlideCurve = iota
+	// GlideExponential moves quickly at first and then slows down as it approaches the new note, like an analog
+	// portamento circuit.
+	GlideExponential
+	// GlideSmooth eases in and out of the glide.
+	GlideSmooth
+)
+
+// shape maps the progress through a glide, in the range 0 to 1, to the progress through the change in pitch.
+func (c GlideCurve) shape(p float64) float64 {
+	switch c {
+	case GlideExponential:
+		return (1 - math.Exp(-5*p)) / (1 - math.Exp(-5))
+	case GlideSmooth:
+		return p * p * (3 - 2*p)
+	}
+
+	return p
+}
+
+// MonoSettings controls how a synth plays overlapping notes when it is used monophonically.
+type MonoSettings struct {
+	// Priority decides which note plays when several are held.
+	Priority NotePriority
+
+	// Legato stops the envelope from being retriggered when moving from one held note to another.
+	Legato bool
+
+	// Glide is the portamento time in seconds when moving from one held note to another. A glide of 0 jumps
+	// straight to the new note.
+	Glide float64
+	// GlideCurve is the shape of the glide.
+	GlideCurve GlideCurve
+}
+
+// glide keeps track of a change in frequency over time.
+type glide struct {
+	from     float64
+	start    float64
+	duration float64
+	curve    GlideCurve
+}
+
+// freq returns the frequency at time `t` when gliding towards `to`.
+func (g *glide) freq(t, to float64) float64 {
+	if g.duration <= 0 || g.from <= 0 || to <= 0 || t >= g.start+g.duration {
+		return to
+	}
+
+	p := g.curve.shape(math.Max(0, (t-g.start)/g.duration))
+	return g.from * math.Pow(to/g.from, p)
+}
+
+// jump cancels any glide so that the frequency changes immediately.
+func (g *glide) jump(freq float64) {
+	g.from = freq
+	g.duration = 0
+}
+
+// heldNote is a note held down on a monophonic synth.
+type heldNote struct {
+	id       NoteID
+	freq     float64
+	velocity float64
+}
+
+// noteStack is the list of held notes on a monophonic synth, in the order they were pressed.
+type noteStack []heldNote
+
+// current returns the note which should be playing according to the note priority.
+func (ns noteStack) current(priority NotePriority) (heldNote, bool) {
+	if len(ns) == 0 {
+		return heldNote{}, false
+	}
+
+	best := ns[len(ns)-1]
+	for i := len(ns) - 2; i >= 0; i-- {
+		switch {
+		case priority == PriorityLow && ns[i].freq < best.freq:
+			best = ns[i]
+		case priority == PriorityHigh && ns[i].freq > best.freq:
+			best = ns[i]
+		}
+	}
+
+	return best, true
+}
+
+// remove removes the note with the given ID from the stack, returning whether it was found.
+func (ns *noteStack) remove(id NoteID) bool {
+	for i, n := range *ns {
+		if n.id == id {
+			*ns = append((*ns)[:i], (*ns)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// NoteOn starts a note with a velocity in the range 0 to 1, keeping track of any other held notes so that releasing
+// this one returns to the previous note. It returns an ID which should be passed to NoteOff.
+func (s *Synth) NoteOn(freq, velocity float64) NoteID {
+	s.m.Lock()
+	s.lastID++
+	id := s.lastID
+	s.m.Unlock()
+
+	s.noteOn(getGlobalTime(), id, freq, velocity)
+	return id
+}
+
+// NoteOff releases a note started with NoteOn. If other notes are still held, the synth moves to the next one
+// according to the note priority, otherwise the envelope is released.
+func (s *Synth) NoteOff(id NoteID) {
+	s.noteOff(getGlobalTime(), id)
+}
+
+// noteOn adds a note to the note stack at time `t`, playing it if it takes priority.
+func (s *Synth) noteOn(t float64, id NoteID, freq, velocity float64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	prev, playing := s.notes.current(s.Mono.Priority)
+	s.notes = append(s.notes, heldNote{id, freq, velocity})
+
+	next, _ := s.notes.current(s.Mono.Priority)
+	if playing && next.id == prev.id {
+		return
+	}
+
+	s.play(t, next, playing)
+}
+
+// noteOff removes a note from the note stack at time `t`, moving to the next held note if it was playing.
+func (s *Synth) noteOff(t float64, id NoteID) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cur, playing := s.notes.current(s.Mono.Priority)
+	if !s.notes.remove(id) || !playing || cur.id != id {
+		return
+	}
+
+	next, held := s.notes.current(s.Mono.Priority)
+	if !held {
+		s.releaseLocked(t)
+		return
+	}
+
+	s.play(t, next, true)
+}
+
+// play moves the synth to a note at time `t`. If `fromHeld` is true, the synth was already playing a held note, so
+// it can glide or play legato. It must be called with the lock held.
+func (s *Synth) play(t float64, n heldNote, fromHeld bool) {
+	from := s.glide.freq(t, s.freq.At(t))
+
+	if !fromHeld || s.finished || !s.Mono.Legato {
+		s.attackLocked(t, n.freq, n.velocity)
+	} else {
+		s.freq.Jump(n.freq)
+	}
+
+	if fromHeld && s.Mono.Glide > 0 {
+		s.glide = glide{from: from, start: t, duration: s.Mono.Glide, curve: s.Mono.GlideCurve}
+	}
+}
+
+// setNoteFreq changes the frequency of a held note, moving the synth to the new frequency if it is playing.
+func (s *Synth) setNoteFreq(id NoteID, freq float64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i := range s.notes {
+		if s.notes[i].id == id {
+			s.notes[i].freq = freq
+		}
+	}
+
+	if cur, ok := s.notes.current(s.Mono.Priority); ok && cur.id == id {
+		s.freq.Set(freq)
+		s.glide.jump(freq)
+	}
+}
+
+// heldFreq returns the IDs of held notes started with a given frequency.
+func (s *Synth) heldFreq(freq float64) []NoteID {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var ids []NoteID
+	for _, n := range s.notes {
+		if n.freq == freq {
+			ids = append(ids, n.id)
+		}
+	}
+
+	return ids
+}
+
+// clearNotes forgets every held note.
+func (s *Synth) clearNotes() {
+	s.m.Lock()
+	s.notes = nil
+	s.m.Unlock()
+}
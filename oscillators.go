@@ -5,37 +5,142 @@ import (
 	"math/rand"
 )
 
-// OscParams contains parameters which control an oscillator.
+// OscParams contains parameters which control an oscillator. They are safe to change while the oscillator is playing,
+// and ramp to new values rather than jumping: linearly for the amplitude and exponentially for the frequency, over
+// DefaultRampTime. Use SetRamp on either of them to change how they ramp.
 type OscParams struct {
-	Amplitude, Frequency float64
+	Amplitude, Frequency *Param
+}
+
+// newOscParams returns the parameters for a new oscillator.
+func newOscParams(amp, freq float64) *OscParams {
+	return &OscParams{
+		Amplitude: NewParam(amp),
+		Frequency: NewParamRamp(freq, DefaultRampTime, ExponentialRamp),
+	}
 }
 
 // Freq gets the frequency from a OscParam struct.
 // These methods may seem redundant but they are used so that oscillators can easily fufil the Oscillator interface by
 // just embedding the OscParams struct.
 func (p *OscParams) Freq() float64 {
-	return p.Frequency
+	return p.Frequency.Target()
 }
 
 // SetFreq sets the frequency for an OscParam struct.
 // These methods may seem redundant but they are used so that oscillators can easily fufil the Oscillator interface by
 // just embedding the OscParams struct.
 func (p *OscParams) SetFreq(f float64) {
-	p.Frequency = f
+	p.Frequency.Set(f)
 }
 
 // Amp gets the amplitude from a OscParam struct.
 // These methods may seem redundant but they are used so that oscillators can easily fufil the Oscillator interface by
 // just embedding the OscParams struct.
 func (p *OscParams) Amp() float64 {
-	return p.Amplitude
+	return p.Amplitude.Target()
 }
 
 // SetAmp sets the amplitude for an OscParam struct.
 // These methods may seem redundant but they are used so that oscillators can easily fufil the Oscillator interface by
 // just embedding the OscParams struct.
 func (p *OscParams) SetAmp(a float64) {
-	p.Amplitude = a
+	p.Amplitude.Set(a)
+}
+
+// oscState is the phase of an oscillator in cycles, which it keeps track of between samples. The phase moves on by the
+// frequency each sample rather than being worked out from the time, so that changing the frequency doesn't make it
+// jump.
+//
+// It starts afresh the first time the oscillator is streamed, so copies of an oscillator, such as the ones made for
+// each voice of a PolySynth, don't share it.
+type oscState struct {
+	started     bool
+	phase, last float64
+}
+
+// advance moves the oscillator on to time `t`, and returns its amplitude and phase.
+func (s *oscState) advance(p *OscParams, t float64) (amp, phase float64) {
+	freq := p.Frequency.At(t)
+
+	// An oscillator which is streamed from an earlier time starts again, at the phase it would have reached if it had
+	// always been at its current frequency.
+	if !s.started || t < s.last {
+		s.started = true
+		s.phase = freq * t
+	} else {
+		s.phase += freq * (t - s.last)
+	}
+
+	s.phase -= math.Floor(s.phase)
+	s.last = t
+
+	return p.Amplitude.At(t), s.phase
+}
+
+// The waveform functions below give the shape of each oscillator at a phase in cycles. Unlike the oscillators, they
+// don't keep any state or allocate, so they can be called for every sample, such as in the stream function of a
+// Synth, which keeps track of the phase itself.
+
+// SineWave returns a sine wave at a phase.
+func SineWave(amp, phase float64) float64 {
+	return amp * math.Sin(2*math.Pi*phase)
+}
+
+// SquareWave returns a square wave at a phase.
+func SquareWave(amp, phase float64) float64 {
+	if amp*math.Sin(2*math.Pi*phase) > 0 {
+		return 1
+	}
+
+	return -1
+}
+
+// PulseWave returns a pulse wave at a phase, where the width is the fraction of each cycle spent high.
+func PulseWave(amp, phase, width float64) float64 {
+	width = math.Max(0.01, math.Min(width, 0.99))
+	if phase-math.Floor(phase) < width {
+		return amp
+	}
+
+	return -amp
+}
+
+// AnalogSquareWave returns an analog square wave at a phase, made from a number of harmonics.
+func AnalogSquareWave(amp, phase float64, iterations int) float64 {
+	output := 0.0
+
+	for i := 1.0; i <= float64(iterations); i++ {
+		output += ((1 - math.Cos(math.Pi*i)) / (math.Pi * i)) * math.Sin(2*math.Pi*phase*i)
+	}
+
+	return output * (2.0 / math.Pi) * amp
+}
+
+// TriangleWave returns a triangle wave at a phase.
+func TriangleWave(amp, phase float64) float64 {
+	return math.Asin(amp * math.Sin(2*math.Pi*phase) * (2 / math.Pi))
+}
+
+// SawtoothWave returns a sawtooth wave at a phase.
+func SawtoothWave(amp, phase float64) float64 {
+	return amp * (2*(phase-math.Floor(phase)) - 1)
+}
+
+// AnalogSawtoothWave returns an analog sawtooth wave at a phase, made from a number of harmonics.
+func AnalogSawtoothWave(amp, phase float64, iterations int) float64 {
+	output := 0.0
+
+	for i := 1.0; i <= float64(iterations); i++ {
+		output += math.Sin(i*2*math.Pi*phase) / i
+	}
+
+	return output * (2.0 / math.Pi) * amp
+}
+
+// NoiseWave returns a random sample of white noise.
+func NoiseWave(amp float64) float64 {
+	return amp * (2.0*rand.Float64() - 1)
 }
 
 // Oscillator is any streamer that provides a repeating, oscillating signal.
@@ -51,38 +156,58 @@ type Oscillator interface {
 // Sine is a sine wave.
 type Sine struct {
 	*OscParams
+	state oscState
 }
 
 // Stream generates the required sample for a given point on a sine wave.
 func (w *Sine) Stream(t float64) float64 {
-	return w.Amp() * math.Sin(W(w.Freq())*t)
+	return SineWave(w.state.advance(w.OscParams, t))
 }
 
 // NewSine returns a new sine wave.
 func NewSine(amp, freq float64) *Sine {
 	return &Sine{
-		&OscParams{amp, freq},
+		OscParams: newOscParams(amp, freq),
 	}
 }
 
 // Square is a square wave.
 type Square struct {
 	*OscParams
+	state oscState
 }
 
 // Stream generates the required sample for a given point on a square wave.
 func (w *Square) Stream(t float64) float64 {
-	if w.Amp()*math.Sin(W(w.Freq())*t) > 0 {
-		return 1
-	}
-
-	return -1
+	return SquareWave(w.state.advance(w.OscParams, t))
 }
 
 // NewSquare returns a new square wave.
 func NewSquare(amp, freq float64) *Square {
 	return &Square{
-		&OscParams{amp, freq},
+		OscParams: newOscParams(amp, freq),
+	}
+}
+
+// Pulse is a pulse wave. It is a square wave where the fraction of each cycle spent high is set by the width, which
+// is in the range 0 to 1.
+type Pulse struct {
+	*OscParams
+	Width float64
+	state oscState
+}
+
+// Stream generates the required sample for a given point on a pulse wave.
+func (w *Pulse) Stream(t float64) float64 {
+	amp, phase := w.state.advance(w.OscParams, t)
+	return PulseWave(amp, phase, w.Width)
+}
+
+// NewPulse returns a new pulse wave.
+func NewPulse(amp, freq, width float64) *Pulse {
+	return &Pulse{
+		OscParams: newOscParams(amp, freq),
+		Width:     width,
 	}
 }
 
@@ -90,41 +215,38 @@ func NewSquare(amp, freq float64) *Square {
 type AnalogSquare struct {
 	*OscParams
 	Iterations int
+	state      oscState
 }
 
 // Stream generates the required sample for a given point on an analog square wave.
 func (w *AnalogSquare) Stream(t float64) float64 {
-	output := 0.0
-
-	for i := 1.0; i <= float64(w.Iterations); i++ {
-		output += ((1 - math.Cos(math.Pi*i)) / (math.Pi * i)) * math.Sin(W(w.Freq())*t*i)
-	}
-
-	return output * (2.0 / math.Pi) * w.Amp()
+	amp, phase := w.state.advance(w.OscParams, t)
+	return AnalogSquareWave(amp, phase, w.Iterations)
 }
 
 // NewAnalogSquare returns a new analog square wave.
 func NewAnalogSquare(amp, freq float64, iterations int) *AnalogSquare {
 	return &AnalogSquare{
-		&OscParams{amp, freq},
-		iterations,
+		OscParams:  newOscParams(amp, freq),
+		Iterations: iterations,
 	}
 }
 
 // Triangle is a triange wave.
 type Triangle struct {
 	*OscParams
+	state oscState
 }
 
 // Stream generates the required sample for a given point on a trainge wave.
 func (w *Triangle) Stream(t float64) float64 {
-	return math.Asin(w.Amp() * math.Sin(W(w.Freq())*t) * (2 / math.Pi))
+	return TriangleWave(w.state.advance(w.OscParams, t))
 }
 
 // NewTriangle returns a new triangle wave.
 func NewTriangle(amp, freq float64) *Triangle {
 	return &Triangle{
-		&OscParams{amp, freq},
+		OscParams: newOscParams(amp, freq),
 	}
 }
 
@@ -132,17 +254,18 @@ func NewTriangle(amp, freq float64) *Triangle {
 // This sawtooth wave is built using the mod function.
 type Sawtooth struct {
 	*OscParams
+	state oscState
 }
 
 // Stream generates the required sample for a given point on a sawtooth wave.
 func (w *Sawtooth) Stream(t float64) float64 {
-	return (2.0 * w.Amp() / math.Pi) * (w.Freq()*math.Pi*math.Mod(t, 1.0/w.Freq()) - (math.Pi / 2.0))
+	return SawtoothWave(w.state.advance(w.OscParams, t))
 }
 
 // NewSawtooth returns a new sawtooth wave.
 func NewSawtooth(amp, freq float64) *Sawtooth {
 	return &Sawtooth{
-		&OscParams{amp, freq},
+		OscParams: newOscParams(amp, freq),
 	}
 }
 
@@ -151,40 +274,38 @@ func NewSawtooth(amp, freq float64) *Sawtooth {
 type AnalogSawtooth struct {
 	*OscParams
 	Iterations int
+	state      oscState
 }
 
 // Stream generates the required sample for a given point on an analog sawtooth wave.
 func (w *AnalogSawtooth) Stream(t float64) float64 {
-	output := 0.0
-
-	for i := 1.0; i <= float64(w.Iterations); i++ {
-		output += (math.Sin(i * W(w.Freq()) * t)) / i
-	}
-
-	return output * (2.0 / math.Pi) * w.Amp()
+	amp, phase := w.state.advance(w.OscParams, t)
+	return AnalogSawtoothWave(amp, phase, w.Iterations)
 }
 
 // NewAnalogSawtooth returns a new sawtooth wave.
 func NewAnalogSawtooth(amp, freq float64, i int) *AnalogSawtooth {
 	return &AnalogSawtooth{
-		&OscParams{amp, freq},
-		i,
+		OscParams:  newOscParams(amp, freq),
+		Iterations: i,
 	}
 }
 
 // Noise represents random noise.
 type Noise struct {
 	*OscParams
+	state oscState
 }
 
 // Stream generates random samples.
 func (w *Noise) Stream(t float64) float64 {
-	return w.Amp() * (2.0*rand.Float64() - 1)
+	amp, _ := w.state.advance(w.OscParams, t)
+	return NoiseWave(amp)
 }
 
 // NewNoise returns a new noise oscillator.
 func NewNoise(amp float64) *Noise {
 	return &Noise{
-		&OscParams{amp, 0},
+		OscParams: newOscParams(amp, 0),
 	}
 }
@@ -0,0 +1,111 @@
+package synth
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mitchellh/copystructure"
+)
+
+// TestOscillatorCopy checks that a copy of an oscillator, such as the one each voice of a PolySynth gets, plays the
+// same as the original.
+func TestOscillatorCopy(t *testing.T) {
+	src := &OscillatorSource{Osc: NewSine(1, 1)}
+
+	c, err := copystructure.Copy(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x, y := src.Value(nil, 0.25), c.(*OscillatorSource).Value(nil, 0.25); math.Abs(x-1) > 1e-9 || x != y {
+		t.Fatalf("expected the original and the copy to be 1, got %v and %v", x, y)
+	}
+
+	c.(*OscillatorSource).Osc.SetAmp(0.5)
+	if a := src.Osc.Amp(); a != 1 {
+		t.Fatalf("expected changing the copy to leave the original alone, got an amplitude of %v", a)
+	}
+}
+
+// TestOscillatorSetFreq checks that changing the frequency of an oscillator while it plays doesn't make it jump.
+func TestOscillatorSetFreq(t *testing.T) {
+	s := NewSine(1, 440)
+
+	// The largest change between samples of a full-scale sine wave at 880Hz.
+	limit := 2 * math.Pi * 880 / float64(sr)
+
+	prev := s.Stream(0)
+	for n := 1; n < int(sr); n++ {
+		at := float64(n) / float64(sr)
+		if n == 10000 {
+			// The ramp is started at the time being streamed rather than on the global clock.
+			s.Frequency.SetAt(at, 880)
+		}
+
+		x := s.Stream(at)
+		if math.Abs(x-prev) > limit {
+			t.Fatalf("sample %d jumped from %v to %v", n, prev, x)
+		}
+
+		prev = x
+	}
+
+	if f := s.Frequency.At(1); f != 880 {
+		t.Fatalf("expected the frequency to have reached 880, got %v", f)
+	}
+}
+
+// TestOscillatorConcurrentSetFreq changes the frequency and amplitude of an oscillator from another goroutine while it
+// streams. It is meant to be run with the race detector.
+func TestOscillatorConcurrentSetFreq(t *testing.T) {
+	s := NewSine(1, 440)
+
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			s.SetFreq(float64(220 + i%1000))
+			s.SetAmp(float64(i%10) / 10)
+			s.Freq()
+			s.Amp()
+
+			if i == 0 {
+				close(started)
+			}
+		}
+	}()
+
+	// The oscillator is only streamed once the frequency is being changed, so that the two overlap.
+	<-started
+	for n := 0; n < int(sr); n++ {
+		s.Stream(float64(n) / float64(sr))
+	}
+
+	close(stop)
+	<-done
+}
+
+// TestOscillatorAllocs checks that streaming an oscillator doesn't allocate.
+func TestOscillatorAllocs(t *testing.T) {
+	s := NewSine(1, 440)
+
+	n := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		n++
+		s.Stream(float64(n) / float64(sr))
+	})
+
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
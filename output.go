@@ -0,0 +1,123 @@
+package synth
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// OutputProtection is how the audio output stops samples outside the range -1 to 1 from wrapping around when they
+// are converted to 16-bit, which sounds like very loud digital noise.
+type OutputProtection int32
+
+const (
+	// ClampOutput cuts off samples outside the range -1 to 1. It is the default.
+	ClampOutput OutputProtection = iota
+	// SoftClipOutput rounds off loud samples smoothly, which sounds less harsh than clamping. Quiet samples are left
+	// alone.
+	SoftClipOutput
+	// AutoGainOutput turns the whole output down as soon as it gets too loud, then slowly turns it back up again.
+	AutoGainOutput
+)
+
+const (
+	// softClipThreshold is the level above which SoftClipOutput starts rounding off samples.
+	softClipThreshold = 0.8
+	// autoGainRecovery is roughly how long AutoGainOutput takes to turn the output back up, in seconds.
+	autoGainRecovery = 5.0
+)
+
+// outputStage prepares the samples from NoiseFunc to be sent to the audio output. The settings can be changed from
+// any goroutine, but the rest of its state is only used by the audio goroutine.
+type outputStage struct {
+	protection int32
+	dither     uint32
+	clips      uint64
+
+	gain float64
+	seed uint64
+}
+
+var output = &outputStage{gain: 1, seed: 1}
+
+// SetOutputProtection sets how the audio output deals with samples outside the range -1 to 1.
+func SetOutputProtection(protection OutputProtection) {
+	atomic.StoreInt32(&output.protection, int32(protection))
+}
+
+// SetDither sets whether TPDF dither is added when converting to 16-bit. Dither turns the distortion of quiet sounds
+// caused by rounding into a very quiet, steady hiss.
+func SetDither(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+
+	atomic.StoreUint32(&output.dither, v)
+}
+
+// ClipCount returns the number of samples which have been outside the range -1 to 1 before output protection was
+// applied. Any clipping means the mix is too loud and should be turned down. Clipping isn't reported anywhere else, so
+// programs which want to warn about it should check this now and then.
+func ClipCount() uint64 {
+	return atomic.LoadUint64(&output.clips)
+}
+
+// ResetClipCount sets the number of clipped samples back to zero.
+func ResetClipCount() {
+	atomic.StoreUint64(&output.clips, 0)
+}
+
+// process applies output protection to a pair of samples.
+func (o *outputStage) process(l, r float64) (float64, float64) {
+	peak := math.Max(math.Abs(l), math.Abs(r))
+	if peak > 1 {
+		atomic.AddUint64(&o.clips, 1)
+	}
+
+	switch OutputProtection(atomic.LoadInt32(&o.protection)) {
+	case SoftClipOutput:
+		l, r = softClip(l), softClip(r)
+	case AutoGainOutput:
+		if peak*o.gain > 1 {
+			o.gain = 1 / peak
+		} else {
+			o.gain = 1 + timeCoefficient(autoGainRecovery)*(o.gain-1)
+		}
+
+		l, r = l*o.gain, r*o.gain
+	}
+
+	return clamp(l, 1), clamp(r, 1)
+}
+
+// toPCM converts a sample to 16-bit PCM and writes it to the start of buf, adding dither if it is turned on.
+func (o *outputStage) toPCM(buf []byte, val float64) {
+	if atomic.LoadUint32(&o.dither) == 1 {
+		// The difference of two uniform random numbers has a triangular distribution, spanning one bit either way.
+		val += (o.random() - o.random()) / (1<<15 - 1)
+	}
+
+	sampleToPCM(buf, val)
+}
+
+// random returns a random number in the range 0 to 1. It is a simple xorshift generator, which is much faster than
+// math/rand and doesn't need a lock.
+func (o *outputStage) random() float64 {
+	o.seed ^= o.seed << 13
+	o.seed ^= o.seed >> 7
+	o.seed ^= o.seed << 17
+
+	return float64(o.seed>>11) / (1 << 53)
+}
+
+// softClip leaves samples below softClipThreshold alone and smoothly rounds off louder ones so that they never go
+// above 1.
+func softClip(x float64) float64 {
+	a := math.Abs(x)
+	if a <= softClipThreshold {
+		return x
+	}
+
+	y := softClipThreshold + (1-softClipThreshold)*math.Tanh((a-softClipThreshold)/(1-softClipThreshold))
+	return math.Copysign(y, x)
+}
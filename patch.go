@@ -0,0 +1,679 @@
+package synth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Patch is a serialisable description of an instrument, which can be saved to and loaded from JSON files so that
+// sounds can be designed without writing Go.
+type Patch struct {
+	Name        string `json:"name,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Amp float64 `json:"amp"`
+	Pan float64 `json:"pan,omitempty"`
+
+	Oscillators []OscillatorPatch `json:"oscillators"`
+	Envelope    EnvelopePatch     `json:"envelope"`
+	Velocity    *VelocityPatch    `json:"velocity,omitempty"`
+	Filter      *FilterPatch      `json:"filter,omitempty"`
+	Mod         []ModPatch        `json:"mod,omitempty"`
+
+	// Polyphony is the number of voices used by PolySynth. If it is 0, DefaultPolyphony is used.
+	Polyphony int        `json:"polyphony,omitempty"`
+	Mono      *MonoPatch `json:"mono,omitempty"`
+
+	// Effects is the chain of effects which the output of the instrument goes through, in order. Effects process the
+	// whole instrument rather than each voice, so they aren't part of the synth; use Chain to put them around it.
+	Effects []EffectPatch `json:"effects,omitempty"`
+}
+
+// OscillatorPatch describes one of the oscillators mixed together to make the sound of a patch.
+type OscillatorPatch struct {
+	// Type is one of "sine", "square", "analogsquare", "triangle", "sawtooth", "analogsawtooth", "pulse" or "noise".
+	Type string `json:"type"`
+	// Level is how loud the oscillator is in the mix.
+	Level float64 `json:"level"`
+	// Ratio is the frequency of the oscillator relative to the note. If it is 0, a ratio of 1 is used.
+	Ratio float64 `json:"ratio,omitempty"`
+	// Detune shifts the frequency of the oscillator, in cents.
+	Detune float64 `json:"detune,omitempty"`
+	// Iterations is the number of harmonics used by the analog oscillators.
+	Iterations int `json:"iterations,omitempty"`
+	// Width is the width of a pulse oscillator.
+	Width float64 `json:"width,omitempty"`
+	// Vibrato wobbles the time passed to the oscillator.
+	Vibrato *VibratoPatch `json:"vibrato,omitempty"`
+}
+
+// VibratoPatch wobbles the time passed to an oscillator back and forth by Depth seconds, Rate times a second.
+type VibratoPatch struct {
+	Depth float64 `json:"depth"`
+	Rate  float64 `json:"rate"`
+}
+
+// EnvelopePatch describes an envelope.
+type EnvelopePatch struct {
+	// Type is one of "ad", "asr" or "adsr".
+	Type string `json:"type"`
+
+	Amplitude float64 `json:"amplitude"`
+	Sustain   float64 `json:"sustain,omitempty"`
+	Attack    float64 `json:"attack"`
+	Decay     float64 `json:"decay,omitempty"`
+	Release   float64 `json:"release,omitempty"`
+}
+
+// VelocityPatch describes how the velocity of a note affects the sound.
+type VelocityPatch struct {
+	// Curve is one of "linear", "soft", "hard" or "fixed".
+	Curve string `json:"curve,omitempty"`
+
+	Amp    float64 `json:"amp"`
+	Time   float64 `json:"time,omitempty"`
+	Cutoff float64 `json:"cutoff,omitempty"`
+}
+
+// FilterPatch describes the filter of a patch.
+type FilterPatch struct {
+	// Type is one of "biquad", "ladder" or "svf".
+	Type string `json:"type"`
+	// Mode is one of "lowpass", "highpass", "bandpass", "notch", "peaking", "lowshelf" or "highshelf". It is
+	// ignored by the ladder filter.
+	Mode string `json:"mode,omitempty"`
+
+	Cutoff    float64 `json:"cutoff"`
+	Q         float64 `json:"q,omitempty"`
+	Gain      float64 `json:"gain,omitempty"`
+	Resonance float64 `json:"resonance,omitempty"`
+	Drive     float64 `json:"drive,omitempty"`
+
+	Envelope      *EnvelopePatch `json:"envelope,omitempty"`
+	EnvelopeDepth float64        `json:"envelopeDepth,omitempty"`
+	KeyTrack      float64        `json:"keyTrack,omitempty"`
+}
+
+// ModPatch describes a modulation route.
+type ModPatch struct {
+	// Source is one of "lfo", "envelope", "velocity", "key", "random", "modwheel", "aftertouch" or "controller".
+	Source string  `json:"source"`
+	Dest   string  `json:"dest"`
+	Depth  float64 `json:"depth"`
+
+	LFO        *LFOPatch      `json:"lfo,omitempty"`
+	Envelope   *EnvelopePatch `json:"envelope,omitempty"`
+	Controller int            `json:"controller,omitempty"`
+}
+
+// LFOPatch describes an LFO.
+type LFOPatch struct {
+	// Shape is one of "sine", "triangle", "saw", "square", "samplehold" or "smoothrandom".
+	Shape string  `json:"shape"`
+	Rate  float64 `json:"rate,omitempty"`
+	// Sync is a note length such as "1/4", "1/8T" (triplet) or "1/8D" (dotted). If it is set, Rate is ignored.
+	Sync string `json:"sync,omitempty"`
+
+	Retrigger bool    `json:"retrigger,omitempty"`
+	Phase     float64 `json:"phase,omitempty"`
+	Delay     float64 `json:"delay,omitempty"`
+	Fade      float64 `json:"fade,omitempty"`
+	Unipolar  bool    `json:"unipolar,omitempty"`
+}
+
+// MonoPatch makes a patch monophonic.
+type MonoPatch struct {
+	// Priority is one of "last", "low" or "high".
+	Priority string  `json:"priority,omitempty"`
+	Legato   bool    `json:"legato,omitempty"`
+	Glide    float64 `json:"glide,omitempty"`
+	// GlideCurve is one of "linear", "exponential" or "smooth".
+	GlideCurve string `json:"glideCurve,omitempty"`
+}
+
+// EffectPatch describes an effect. Each type of effect only uses some of the fields.
+type EffectPatch struct {
+	// Type is one of "delay", "reverb", "chorus", "flanger", "phaser", "distortion", "bitcrusher", "compressor",
+	// "limiter", "gate" or "eq".
+	Type string `json:"type"`
+	// Mix is how much of the effect is heard, from 0 to 1. It is ignored by the dynamics effects and the EQ.
+	Mix float64 `json:"mix,omitempty"`
+
+	// Time is the delay time in milliseconds.
+	Time float64 `json:"time,omitempty"`
+	// Sync is a note length such as "1/8D" for the delay time at the global tempo. If it is set, Time is ignored.
+	Sync     string  `json:"sync,omitempty"`
+	Feedback float64 `json:"feedback,omitempty"`
+	PingPong bool    `json:"pingPong,omitempty"`
+
+	RoomSize float64 `json:"roomSize,omitempty"`
+	Damping  float64 `json:"damping,omitempty"`
+
+	// Rate is the LFO rate of the chorus, flanger and phaser in hertz, or the reduced sample rate of the bitcrusher.
+	Rate  float64 `json:"rate,omitempty"`
+	Depth float64 `json:"depth,omitempty"`
+	// Voices is the number of chorus voices or phaser stages.
+	Voices int `json:"voices,omitempty"`
+
+	// Shape is one of "soft", "hard", "foldback" or "tube".
+	Shape string  `json:"shape,omitempty"`
+	Drive float64 `json:"drive,omitempty"`
+	Bits  float64 `json:"bits,omitempty"`
+
+	// Threshold and Ceiling are in decibels, and Attack and Release are in seconds.
+	Threshold float64 `json:"threshold,omitempty"`
+	Ratio     float64 `json:"ratio,omitempty"`
+	Ceiling   float64 `json:"ceiling,omitempty"`
+	Attack    float64 `json:"attack,omitempty"`
+	Release   float64 `json:"release,omitempty"`
+
+	Bands []EQBandPatch `json:"bands,omitempty"`
+}
+
+// EQBandPatch describes a band of an EQ.
+type EQBandPatch struct {
+	// Mode is one of the filter modes, usually "peaking", "lowshelf" or "highshelf". An empty mode is a peaking band.
+	Mode string  `json:"mode,omitempty"`
+	Freq float64 `json:"freq"`
+	Gain float64 `json:"gain"`
+	// Q is the width of the band. If it is 0, a Q of 1 is used.
+	Q float64 `json:"q,omitempty"`
+}
+
+// LoadPatch reads a patch from JSON.
+func LoadPatch(r io.Reader) (*Patch, error) {
+	p := &Patch{}
+
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, fmt.Errorf("could not decode patch: %v", err)
+	}
+
+	return p, nil
+}
+
+// LoadPatchFile reads a patch from a JSON file.
+func LoadPatchFile(path string) (*Patch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open patch: %v", err)
+	}
+	defer f.Close()
+
+	return LoadPatch(f)
+}
+
+// SavePatch writes a patch as JSON.
+func SavePatch(w io.Writer, p *Patch) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(p); err != nil {
+		return fmt.Errorf("could not encode patch: %v", err)
+	}
+
+	return nil
+}
+
+// SavePatchFile writes a patch to a JSON file.
+func SavePatchFile(path string, p *Patch) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create patch: %v", err)
+	}
+
+	if err := SavePatch(f, p); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Synth builds a synth from the patch.
+func (p *Patch) Synth() (*Synth, error) {
+	oscs := make([]func(v *Voice, t float64) float64, 0, len(p.Oscillators))
+	for _, op := range p.Oscillators {
+		osc, err := op.build()
+		if err != nil {
+			return nil, err
+		}
+
+		oscs = append(oscs, osc)
+	}
+
+	env, err := p.Envelope.build()
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewVoiceSynth(func(v *Voice, t float64) float64 {
+		output := 0.0
+
+		for _, osc := range oscs {
+			output += osc(v, t)
+		}
+
+		return output
+	}, env, p.Amp)
+
+	s.Pan = p.Pan
+
+	if p.Velocity != nil {
+		curve, err := velocityCurve(p.Velocity.Curve)
+		if err != nil {
+			return nil, err
+		}
+
+		s.VelocityAmp = VelocityTarget{Curve: curve, Depth: p.Velocity.Amp}
+		s.VelocityTime = VelocityTarget{Curve: curve, Depth: p.Velocity.Time}
+		s.VelocityCutoff = VelocityTarget{Curve: curve, Depth: p.Velocity.Cutoff}
+	}
+
+	if p.Filter != nil {
+		if err := p.Filter.apply(s); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, mp := range p.Mod {
+		source, err := mp.source()
+		if err != nil {
+			return nil, err
+		}
+
+		s.Route(source, mp.Dest, mp.Depth)
+	}
+
+	if p.Mono != nil {
+		mono, err := p.Mono.settings()
+		if err != nil {
+			return nil, err
+		}
+
+		s.Mono = mono
+	}
+
+	return s, nil
+}
+
+// PolySynth builds a polyphonic synth from the patch. If the patch is mono, the synth is put in mono mode.
+func (p *Patch) PolySynth() (*PolySynth, error) {
+	s, err := p.Synth()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := NewPolySynth(s)
+
+	if p.Polyphony > 0 {
+		ps.SetPolyphony(p.Polyphony)
+	}
+
+	if p.Mono != nil {
+		ps.SetMono(s.Mono)
+	}
+
+	return ps, nil
+}
+
+// Chain returns a chain of the patch's effects around a streamer, which is usually the synth built from the patch.
+func (p *Patch) Chain(input Streamer) (*Chain, error) {
+	effects := make([]Effect, 0, len(p.Effects))
+	for _, ep := range p.Effects {
+		effect, err := ep.build()
+		if err != nil {
+			return nil, err
+		}
+
+		effects = append(effects, effect)
+	}
+
+	return NewChain(input, effects...), nil
+}
+
+// build returns a function which streams the oscillator for a voice.
+func (op OscillatorPatch) build() (func(v *Voice, t float64) float64, error) {
+	// The waveforms are used rather than oscillators, since the stream function is shared by every voice and is
+	// given each voice's phase-continuous time.
+	var stream func(amp, phase, width float64) float64
+
+	switch op.Type {
+	case "sine":
+		stream = func(amp, phase, width float64) float64 { return SineWave(amp, phase) }
+	case "square":
+		stream = func(amp, phase, width float64) float64 { return SquareWave(amp, phase) }
+	case "analogsquare":
+		stream = func(amp, phase, width float64) float64 { return AnalogSquareWave(amp, phase, op.Iterations) }
+	case "triangle":
+		stream = func(amp, phase, width float64) float64 { return TriangleWave(amp, phase) }
+	case "sawtooth":
+		stream = func(amp, phase, width float64) float64 { return SawtoothWave(amp, phase) }
+	case "analogsawtooth":
+		stream = func(amp, phase, width float64) float64 { return AnalogSawtoothWave(amp, phase, op.Iterations) }
+	case "pulse":
+		stream = PulseWave
+	case "noise":
+		stream = func(amp, phase, width float64) float64 { return NoiseWave(amp) }
+	default:
+		return nil, fmt.Errorf("unknown oscillator type %q", op.Type)
+	}
+
+	ratio := op.Ratio
+	if ratio == 0 {
+		ratio = 1
+	}
+	ratio *= math.Pow(2, op.Detune/1200)
+
+	level := op.Level
+	width := op.Width
+	if width == 0 {
+		width = 0.5
+	}
+
+	vibrato := op.Vibrato
+
+	return func(v *Voice, t float64) float64 {
+		if vibrato != nil {
+			t += SineWave(vibrato.Depth, vibrato.Rate*t)
+		}
+
+		return level * stream(v.Amp, v.Freq*ratio*t, width+v.Mod(DestPulseWidth))
+	}, nil
+}
+
+// build returns the envelope described by the patch.
+func (ep EnvelopePatch) build() (Envelope, error) {
+	if ep.Attack < 0 || ep.Decay < 0 || ep.Release < 0 {
+		return nil, fmt.Errorf("envelope durations can't be negative")
+	}
+
+	switch ep.Type {
+	case "ad":
+		return NewADEnvelope(ep.Amplitude, ep.Attack, ep.Decay), nil
+	case "asr":
+		return NewASREnvelope(ep.Amplitude, ep.Attack, ep.Release), nil
+	case "adsr":
+		return NewADSREnvelope(ep.Amplitude, ep.Sustain, ep.Attack, ep.Decay, ep.Release), nil
+	}
+
+	return nil, fmt.Errorf("unknown envelope type %q", ep.Type)
+}
+
+// apply sets up the filter of a synth from the patch.
+func (fp FilterPatch) apply(s *Synth) error {
+	mode, err := filterType(fp.Mode)
+	if err != nil {
+		return err
+	}
+
+	switch fp.Type {
+	case "biquad":
+		b := NewBiquad(mode, fp.Cutoff, fp.Q)
+		b.Gain = fp.Gain
+		s.Filter = b
+	case "ladder":
+		l := NewLadder(fp.Cutoff, fp.Resonance)
+		l.Drive = fp.Drive
+		s.Filter = l
+	case "svf":
+		f := NewSVF(mode, fp.Cutoff, fp.Resonance)
+		f.Drive = fp.Drive
+		s.Filter = f
+	default:
+		return fmt.Errorf("unknown filter type %q", fp.Type)
+	}
+
+	s.Cutoff = fp.Cutoff
+	s.KeyTrack = fp.KeyTrack
+
+	if fp.Envelope != nil {
+		env, err := fp.Envelope.build()
+		if err != nil {
+			return err
+		}
+
+		s.FilterEnv = env
+		s.FilterEnvDepth = fp.EnvelopeDepth
+	}
+
+	return nil
+}
+
+// source returns the modulation source described by the patch.
+func (mp ModPatch) source() (ModSource, error) {
+	switch mp.Source {
+	case "lfo":
+		if mp.LFO == nil {
+			return nil, fmt.Errorf("lfo modulation source has no lfo")
+		}
+
+		return mp.LFO.build()
+	case "envelope":
+		if mp.Envelope == nil {
+			return nil, fmt.Errorf("envelope modulation source has no envelope")
+		}
+
+		env, err := mp.Envelope.build()
+		if err != nil {
+			return nil, err
+		}
+
+		return &EnvSource{Env: env}, nil
+	case "velocity":
+		return VelocitySource, nil
+	case "key":
+		return KeySource, nil
+	case "random":
+		return RandomSource, nil
+	case "modwheel":
+		return ModWheelSource, nil
+	case "aftertouch":
+		return AftertouchSource, nil
+	case "controller":
+		return ControllerSource(mp.Controller), nil
+	}
+
+	return nil, fmt.Errorf("unknown modulation source %q", mp.Source)
+}
+
+// build returns the LFO described by the patch.
+func (lp LFOPatch) build() (*LFO, error) {
+	shapes := map[string]LFOShape{
+		"sine":         LFOSine,
+		"triangle":     LFOTriangle,
+		"saw":          LFOSaw,
+		"square":       LFOSquare,
+		"samplehold":   LFOSampleHold,
+		"smoothrandom": LFOSmoothRandom,
+	}
+
+	shape, ok := shapes[lp.Shape]
+	if !ok {
+		return nil, fmt.Errorf("unknown lfo shape %q", lp.Shape)
+	}
+
+	lfo := &LFO{
+		Shape:     shape,
+		Rate:      lp.Rate,
+		Retrigger: lp.Retrigger,
+		Phase:     lp.Phase,
+		Delay:     lp.Delay,
+		Fade:      lp.Fade,
+		Unipolar:  lp.Unipolar,
+	}
+
+	if lp.Sync != "" {
+		sync, err := ParseDivision(lp.Sync)
+		if err != nil {
+			return nil, err
+		}
+
+		lfo.Sync = sync
+	}
+
+	return lfo, nil
+}
+
+// build returns the effect described by the patch. Its input is set when it is added to a chain.
+func (ep EffectPatch) build() (Effect, error) {
+	switch ep.Type {
+	case "delay":
+		var d *Delay
+		if ep.Sync != "" {
+			div, err := ParseDivision(ep.Sync)
+			if err != nil {
+				return nil, err
+			}
+
+			d = NewSyncedDelay(nil, div, ep.Feedback, ep.Mix)
+		} else {
+			d = NewDelay(nil, ep.Time, ep.Feedback, ep.Mix)
+		}
+
+		d.SetPingPong(ep.PingPong)
+		return d, nil
+	case "reverb":
+		return NewReverb(nil, ep.RoomSize, ep.Damping, ep.Mix), nil
+	case "chorus":
+		return NewChorus(nil, ep.Voices, ep.Rate, ep.Depth, ep.Mix), nil
+	case "flanger":
+		return NewFlanger(nil, ep.Rate, ep.Depth, ep.Feedback, ep.Mix), nil
+	case "phaser":
+		return NewPhaser(nil, ep.Voices, ep.Rate, ep.Depth, ep.Feedback, ep.Mix), nil
+	case "distortion":
+		shapes := map[string]ShapeFunc{"": SoftClip, "soft": SoftClip, "hard": HardClip, "foldback": Foldback, "tube": Tube}
+
+		shape, ok := shapes[ep.Shape]
+		if !ok {
+			return nil, fmt.Errorf("unknown distortion shape %q", ep.Shape)
+		}
+
+		return NewDistortion(nil, shape, ep.Drive, ep.Mix), nil
+	case "bitcrusher":
+		return NewBitcrusher(nil, ep.Bits, ep.Rate, ep.Mix), nil
+	case "compressor":
+		return NewCompressor(nil, ep.Threshold, ep.Ratio, ep.Attack, ep.Release), nil
+	case "limiter":
+		return NewLimiter(nil, ep.Ceiling, ep.Release), nil
+	case "gate":
+		return NewGate(nil, ep.Threshold, ep.Attack, ep.Release), nil
+	case "eq":
+		bands := make([]EQBand, 0, len(ep.Bands))
+		for _, bp := range ep.Bands {
+			mode := Peaking
+			if bp.Mode != "" {
+				var err error
+				if mode, err = filterType(bp.Mode); err != nil {
+					return nil, err
+				}
+			}
+
+			q := bp.Q
+			if q == 0 {
+				q = 1
+			}
+
+			bands = append(bands, EQBand{Type: mode, Freq: bp.Freq, Gain: bp.Gain, Q: q})
+		}
+
+		return NewParametricEQWith(nil, bands...), nil
+	}
+
+	return nil, fmt.Errorf("unknown effect type %q", ep.Type)
+}
+
+// settings returns the mono settings described by the patch.
+func (mp MonoPatch) settings() (MonoSettings, error) {
+	priorities := map[string]NotePriority{"": PriorityLast, "last": PriorityLast, "low": PriorityLow, "high": PriorityHigh}
+	curves := map[string]GlideCurve{"": GlideLinear, "linear": GlideLinear, "exponential": GlideExponential, "smooth": GlideSmooth}
+
+	priority, ok := priorities[mp.Priority]
+	if !ok {
+		return MonoSettings{}, fmt.Errorf("unknown note priority %q", mp.Priority)
+	}
+
+	curve, ok := curves[mp.GlideCurve]
+	if !ok {
+		return MonoSettings{}, fmt.Errorf("unknown glide curve %q", mp.GlideCurve)
+	}
+
+	return MonoSettings{Priority: priority, Legato: mp.Legato, Glide: mp.Glide, GlideCurve: curve}, nil
+}
+
+// filterType returns the filter type with the given name. An empty name is a low-pass filter.
+func filterType(name string) (FilterType, error) {
+	types := map[string]FilterType{
+		"":          LowPass,
+		"lowpass":   LowPass,
+		"highpass":  HighPass,
+		"bandpass":  BandPass,
+		"notch":     Notch,
+		"peaking":   Peaking,
+		"lowshelf":  LowShelf,
+		"highshelf": HighShelf,
+	}
+
+	typ, ok := types[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown filter mode %q", name)
+	}
+
+	return typ, nil
+}
+
+// velocityCurve returns the velocity curve with the given name. An empty name is a linear curve.
+func velocityCurve(name string) (VelocityCurve, error) {
+	switch name {
+	case "", "linear":
+		return LinearVelocity, nil
+	case "soft":
+		return SoftVelocity, nil
+	case "hard":
+		return HardVelocity, nil
+	case "fixed":
+		return FixedVelocity(1), nil
+	}
+
+	return nil, fmt.Errorf("unknown velocity curve %q", name)
+}
+
+// ParseDivision parses a note length such as "1/4", "1/8T" (triplet) or "1/16D" (dotted).
+func ParseDivision(s string) (Division, error) {
+	modifier := byte(0)
+	if strings.HasSuffix(s, "T") || strings.HasSuffix(s, "D") {
+		modifier = s[len(s)-1]
+		s = s[:len(s)-1]
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid note length %q", s)
+	}
+
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid note length %q: %v", s, err)
+	}
+
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0, fmt.Errorf("invalid note length %q", s)
+	}
+
+	d := Division(num / den)
+
+	switch modifier {
+	case 'T':
+		d = d.Triplet()
+	case 'D':
+		d = d.Dotted()
+	}
+
+	return d, nil
+}
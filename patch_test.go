@@ -0,0 +1,57 @@
+package synth
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestPatchZeroDurations checks that a patch whose envelopes have no attack or release still gives a finite output,
+// rather than dividing by zero and poisoning the filter.
+func TestPatchZeroDurations(t *testing.T) {
+	p, err := LoadPatch(strings.NewReader(`{
+		"amp": 1,
+		"oscillators": [{"type": "sawtooth", "level": 1}],
+		"envelope": {"type": "adsr", "amplitude": 1, "sustain": 0.5, "attack": 0, "decay": 0.1, "release": 0},
+		"filter": {
+			"type": "ladder",
+			"cutoff": 1000,
+			"resonance": 0.5,
+			"envelope": {"type": "asr", "amplitude": 1, "attack": 0, "release": 0},
+			"envelopeDepth": 2000
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := p.Synth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.attack(0, 440, 1)
+	for n := 0; n < int(sr)/2; n++ {
+		at := float64(n) / float64(sr)
+		if n == int(sr)/4 {
+			s.release(at)
+		}
+
+		if x := s.Stream(at); math.IsNaN(x) || math.IsInf(x, 0) {
+			t.Fatalf("sample %d is %v", n, x)
+		}
+	}
+}
+
+// TestPatchNegativeDuration checks that a patch with a negative envelope duration is rejected.
+func TestPatchNegativeDuration(t *testing.T) {
+	p := &Patch{
+		Amp:         1,
+		Oscillators: []OscillatorPatch{{Type: "sine", Level: 1}},
+		Envelope:    EnvelopePatch{Type: "asr", Amplitude: 1, Attack: -1},
+	}
+
+	if _, err := p.Synth(); err == nil {
+		t.Fatal("expected an error for a negative attack")
+	}
+}
@@ -0,0 +1,760 @@
+package synth
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/copystructure"
+)
+
+// DefaultPolyphony is the number of voices a new PolySynth has.
+const DefaultPolyphony = 16
+
+// StealFadeTime is how long, in seconds, a stolen voice takes to fade out before it plays its new note. It is short
+// enough to be inaudible as a delay but long enough to avoid clicks.
+const StealFadeTime = 0.005
+
+// StealMode decides which voice is reused when a note is played and every voice of a PolySynth is busy.
+type StealMode int
+
+const (
+	// StealOldest steals the voice which started playing first.
+	StealOldest StealMode = iota
+	// StealQuietest steals the voice with the lowest envelope level.
+	StealQuietest
+	// StealLowest steals the voice playing the lowest note.
+	StealLowest
+	// StealHighest steals the voice playing the highest note.
+	StealHighest
+	// StealReleased steals the voice which was released first, falling back to the oldest voice if every voice is
+	// still held.
+	StealReleased
+)
+
+// PolySynth defines a synth capable of polyphony.
+// Voices are copied from the base synth once, up front, and then reused for each note.
+type PolySynth struct {
+	base   *Synth
+	m      *sync.Mutex
+	voices []*voice
+	notes  map[NoteID]*voice
+	keys   map[midiKey]NoteID
+
+	steal     StealMode
+	mono      bool
+	sustain   bool
+	sustained map[NoteID]bool
+	lastID    NoteID
+}
+
+// NoteID is a handle to a single note played by a PolySynth. It can be used to release the note or change its
+// parameters while it is playing, even if other notes share the same frequency.
+type NoteID uint64
+
+// midiKey identifies a note by its MIDI channel and key number.
+type midiKey struct {
+	channel, key int
+}
+
+// voice is a single copy of the base synth, along with the note it is currently playing.
+type voice struct {
+	synth *Synth
+
+	id       NoteID
+	freq     float64
+	velocity float64
+	active   bool
+
+	on  float64
+	off float64
+
+	released bool
+
+	// stolen is set while the voice fades out. Once the fade has finished, the voice plays its pending note, or is
+	// removed from the pool if it is retiring.
+	stolen   bool
+	stolenAt float64
+	pending  bool
+	retiring bool
+}
+
+// NewPolySynth takes an existing synth and makes it capable of multiple voices, using DefaultPolyphony voices.
+func NewPolySynth(synth *Synth) *PolySynth {
+	ps := &PolySynth{
+		base:  synth,
+		m:     &sync.Mutex{},
+		notes: map[NoteID]*voice{},
+		keys:  map[midiKey]NoteID{},
+
+		sustained: map[NoteID]bool{},
+	}
+
+	ps.SetPolyphony(DefaultPolyphony)
+	return ps
+}
+
+// Stream returns the correct sample for a given point in time `t`.
+func (ps *PolySynth) Stream(t float64) float64 {
+	val, _ := ps.stream(t, false)
+	return val
+}
+
+// StreamStereo returns the correct sample for a given point in time `t`, with each voice panned into the left and
+// right channels.
+func (ps *PolySynth) StreamStereo(t float64) (l, r float64) {
+	return ps.stream(t, true)
+}
+
+// stream sums the output of every playing voice at time `t`, and frees voices which have finished. If `stereo` is
+// false, only the left channel is used.
+func (ps *PolySynth) stream(t float64, stereo bool) (l, r float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	retired := false
+
+	for _, v := range ps.voices {
+		if !v.active {
+			continue
+		}
+
+		var vl, vr float64
+		if stereo {
+			vl, vr = v.synth.StreamStereo(t)
+		} else {
+			vl = v.synth.Stream(t)
+		}
+
+		if v.stolen {
+			gain := 1 - (t-v.stolenAt)/StealFadeTime
+			if gain <= 0 {
+				v.stolen = false
+				retired = retired || v.retiring
+
+				if v.pending && !v.released {
+					v.pending = false
+					ps.start(v, t)
+				} else {
+					ps.free(v)
+				}
+
+				continue
+			}
+
+			vl *= gain
+			vr *= gain
+		} else if v.synth.Finished() {
+			ps.free(v)
+		}
+
+		l += vl
+		r += vr
+	}
+
+	if retired {
+		ps.removeRetired()
+	}
+
+	return l, r
+}
+
+func init() {
+	// Parameters keep their value in an unexported field, which copystructure would leave out. They are cloned instead,
+	// so that each voice gets its own copy of parameters such as those of an oscillator used as a modulation source.
+	copystructure.Copiers[reflect.TypeOf(Param{})] = func(v interface{}) (interface{}, error) {
+		p := v.(Param)
+		return *p.clone(), nil
+	}
+}
+
+// newVoice returns a new voice copied from the base synth.
+func (ps *PolySynth) newVoice() *voice {
+	copied, err := copystructure.Copy(ps.base)
+	if err != nil {
+		panic(err)
+	}
+
+	s := copied.(*Synth)
+	s.m = &sync.Mutex{}
+	s.streamFunc = ps.base.streamFunc
+	s.controls = ps.base.controls
+	s.amp = ps.base.amp.clone()
+	s.freq = ps.base.freq.clone()
+
+	return &voice{synth: s}
+}
+
+// free marks a voice as no longer playing a note. It must be called with the lock held.
+func (ps *PolySynth) free(v *voice) {
+	// In mono mode, several notes can share the same voice.
+	for id, other := range ps.notes {
+		if other == v {
+			delete(ps.notes, id)
+		}
+	}
+
+	if ps.mono {
+		v.synth.clearNotes()
+	}
+
+	v.active = false
+	v.id = 0
+}
+
+// removeRetired removes voices which have finished fading out after the polyphony was reduced. It must be called
+// with the lock held.
+func (ps *PolySynth) removeRetired() {
+	kept := ps.voices[:0]
+	for _, v := range ps.voices {
+		if v.retiring && !v.active {
+			continue
+		}
+
+		kept = append(kept, v)
+	}
+
+	ps.voices = kept
+}
+
+// SetPolyphony sets the maximum number of notes which can play at once. If it is reduced while notes are playing,
+// the extra notes are faded out.
+func (ps *PolySynth) SetPolyphony(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	// Voices which are already retiring can be brought back instead of allocating new ones.
+	for _, v := range ps.voices {
+		if ps.polyphony() >= n {
+			break
+		}
+
+		v.retiring = false
+	}
+
+	for ps.polyphony() < n {
+		ps.voices = append(ps.voices, ps.newVoice())
+	}
+
+	// Free voices can be dropped straight away, playing ones are faded out first.
+	kept := ps.voices[:0]
+	excess := ps.polyphony() - n
+	for _, v := range ps.voices {
+		if excess > 0 && !v.active && !v.retiring {
+			excess--
+			continue
+		}
+
+		kept = append(kept, v)
+	}
+	ps.voices = kept
+
+	now := getGlobalTime()
+	for excess > 0 {
+		v := ps.choose(now)
+		ps.stealVoice(v, now)
+		v.retiring = true
+		excess--
+	}
+}
+
+// polyphony returns the number of voices which are not retiring. It must be called with the lock held.
+func (ps *PolySynth) polyphony() int {
+	n := 0
+	for _, v := range ps.voices {
+		if !v.retiring {
+			n++
+		}
+	}
+
+	return n
+}
+
+// SetMono switches the synth to monophonic mode, where every note is played by a single voice which handles
+// overlapping notes using the given settings. Any notes already playing are faded out.
+func (ps *PolySynth) SetMono(settings MonoSettings) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.stopAll()
+	ps.mono = true
+
+	for _, v := range ps.voices {
+		v.synth.m.Lock()
+		v.synth.Mono = settings
+		v.synth.m.Unlock()
+	}
+}
+
+// SetPoly switches the synth back to polyphonic mode. Any notes already playing are faded out.
+func (ps *PolySynth) SetPoly() {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.stopAll()
+	ps.mono = false
+}
+
+// stopAll fades out every playing voice. It must be called with the lock held.
+func (ps *PolySynth) stopAll() {
+	t := getGlobalTime()
+
+	for _, v := range ps.voices {
+		if v.active && !v.stolen {
+			ps.stealVoice(v, t)
+		}
+	}
+
+	for id := range ps.notes {
+		delete(ps.notes, id)
+	}
+}
+
+// monoVoice returns the voice used in mono mode. It must be called with the lock held.
+func (ps *PolySynth) monoVoice() *voice {
+	for _, v := range ps.voices {
+		if !v.retiring {
+			return v
+		}
+	}
+
+	return ps.voices[0]
+}
+
+// SetStealMode sets how a voice is chosen to play a new note when every voice is busy.
+func (ps *PolySynth) SetStealMode(mode StealMode) {
+	ps.m.Lock()
+	ps.steal = mode
+	ps.m.Unlock()
+}
+
+// choose returns a free voice if there is one, or otherwise a voice to steal according to the steal mode. It must be
+// called with the lock held.
+func (ps *PolySynth) choose(t float64) *voice {
+	var candidates []*voice
+
+	for _, v := range ps.voices {
+		if v.retiring {
+			continue
+		}
+
+		if !v.active {
+			return v
+		}
+
+		candidates = append(candidates, v)
+	}
+
+	// Prefer voices which aren't already being stolen, so that a burst of notes doesn't keep stealing the same one.
+	fresh := candidates[:0:0]
+	for _, v := range candidates {
+		if !v.stolen {
+			fresh = append(fresh, v)
+		}
+	}
+
+	if len(fresh) > 0 {
+		candidates = fresh
+	}
+
+	if ps.steal == StealReleased {
+		var released []*voice
+		for _, v := range candidates {
+			if v.released {
+				released = append(released, v)
+			}
+		}
+
+		if len(released) > 0 {
+			candidates = released
+		}
+	}
+
+	best := candidates[0]
+	score := func(v *voice) float64 {
+		switch ps.steal {
+		case StealQuietest:
+			return v.synth.level(t)
+		case StealLowest:
+			return v.freq
+		case StealHighest:
+			return -v.freq
+		case StealReleased:
+			if v.released {
+				return v.off
+			}
+		}
+
+		return v.on
+	}
+
+	for _, v := range candidates[1:] {
+		if score(v) < score(best) {
+			best = v
+		}
+	}
+
+	return best
+}
+
+// stealVoice starts fading out a voice so that it can be reused. It must be called with the lock held.
+func (ps *PolySynth) stealVoice(v *voice, t float64) {
+	for id, other := range ps.notes {
+		if other == v {
+			delete(ps.notes, id)
+		}
+	}
+
+	v.stolen = true
+	v.stolenAt = t
+	v.pending = false
+}
+
+// getVoice attempts to get the voice playing the note with a given ID.
+func (ps *PolySynth) getVoice(id NoteID) (*voice, bool) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	v, ok := ps.notes[id]
+	return v, ok
+}
+
+// TriggerAttack triggers the attack phase of the Synth's envelope at full velocity. It returns the IDs of the notes
+// started, in the same order as the frequencies given.
+func (ps *PolySynth) TriggerAttack(freq []float64) []NoteID {
+	return ps.TriggerAttackVelocity(freq, 1)
+}
+
+// TriggerAttackVelocity triggers the attack phase of the Synth's envelope with a velocity in the range 0 to 1. It
+// returns the IDs of the notes started, in the same order as the frequencies given.
+func (ps *PolySynth) TriggerAttackVelocity(freq []float64, velocity float64) []NoteID {
+	ids := make([]NoteID, 0, len(freq))
+
+	for _, f := range freq {
+		ids = append(ids, ps.attack(getGlobalTime(), f, velocity))
+	}
+
+	return ids
+}
+
+// attack starts a note at time `t` on a free voice, stealing one if necessary.
+func (ps *PolySynth) attack(t, freq, velocity float64) NoteID {
+	id := ps.reserve(1)[0]
+	ps.attackID(t, id, freq, velocity)
+
+	return id
+}
+
+// reserve returns `n` new note IDs.
+func (ps *PolySynth) reserve(n int) []NoteID {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ids := make([]NoteID, n)
+	for i := range ids {
+		ps.lastID++
+		ids[i] = ps.lastID
+	}
+
+	return ids
+}
+
+// attackID starts a note with a given ID at time `t` on a free voice, stealing one if necessary.
+func (ps *PolySynth) attackID(t float64, id NoteID, freq, velocity float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	if ps.mono {
+		ps.attackMono(t, id, freq, velocity)
+		return
+	}
+
+	v := ps.choose(t)
+
+	stealing := v.active
+	if stealing {
+		ps.stealVoice(v, t)
+	}
+
+	v.id = id
+	v.freq = freq
+	v.velocity = velocity
+	v.active = true
+	v.off = 0
+	v.released = false
+
+	if stealing {
+		v.pending = true
+	} else {
+		ps.start(v, t)
+	}
+
+	ps.notes[id] = v
+}
+
+// attackMono adds a note to the single voice used in mono mode. It must be called with the lock held.
+func (ps *PolySynth) attackMono(t float64, id NoteID, freq, velocity float64) {
+	v := ps.monoVoice()
+
+	if !v.active || v.stolen {
+		v.stolen = false
+		v.pending = false
+		v.synth.clearNotes()
+		v.synth.amp.Jump(ps.base.amp.Target())
+		v.on = t
+	}
+
+	v.synth.noteOn(t, id, freq, velocity)
+
+	v.id = id
+	v.freq = freq
+	v.velocity = velocity
+	v.active = true
+	v.released = false
+
+	ps.notes[id] = v
+}
+
+// start begins playing the note assigned to a voice at time `t`. It must be called with the lock held.
+func (ps *PolySynth) start(v *voice, t float64) {
+	v.synth.amp.Jump(ps.base.amp.Target())
+	v.synth.attack(t, v.freq, v.velocity)
+	v.on = t
+}
+
+// TriggerRelease triggers the release phase of every held note which was started with one of the given frequencies.
+// Prefer ReleaseNote, which releases exactly the notes it is given.
+func (ps *PolySynth) TriggerRelease(freq []float64) {
+	ps.m.Lock()
+	var ids []NoteID
+	if ps.mono {
+		for _, f := range freq {
+			ids = append(ids, ps.monoVoice().synth.heldFreq(f)...)
+		}
+	}
+
+	for id, v := range ps.notes {
+		if v.released || ps.mono {
+			continue
+		}
+
+		for _, f := range freq {
+			if v.freq == f {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	ps.m.Unlock()
+
+	ps.ReleaseNote(ids...)
+}
+
+// ReleaseNote triggers the release phase of the notes with the given IDs. IDs of notes which have already finished
+// are ignored. While the sustain pedal is down, the notes keep playing until it is lifted.
+func (ps *PolySynth) ReleaseNote(ids ...NoteID) {
+	ps.release(getGlobalTime(), ids...)
+}
+
+// release releases the notes with the given IDs at time `t`.
+func (ps *PolySynth) release(t float64, ids ...NoteID) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	for _, id := range ids {
+		if ps.sustain {
+			ps.sustained[id] = true
+			continue
+		}
+
+		ps.releaseID(t, id)
+	}
+}
+
+// releaseID releases the note with the given ID at time `t`. It must be called with the lock held.
+func (ps *PolySynth) releaseID(t float64, id NoteID) {
+	v, ok := ps.notes[id]
+	if !ok || v.released {
+		return
+	}
+
+	if ps.mono {
+		v.synth.noteOff(t, id)
+		delete(ps.notes, id)
+		return
+	}
+
+	v.synth.release(t)
+	v.off = t
+	v.released = true
+}
+
+// SetSustain sets whether the sustain pedal is held down. While it is down, released notes keep playing, and they
+// are all released once it is lifted.
+func (ps *PolySynth) SetSustain(on bool) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.sustain = on
+	if on {
+		return
+	}
+
+	t := getGlobalTime()
+	for id := range ps.sustained {
+		ps.releaseID(t, id)
+		delete(ps.sustained, id)
+	}
+}
+
+// NoteOn starts a note identified by a MIDI channel and key number, with a velocity in the range 0 to 1. If the
+// same key is already held on that channel, the old note is released first.
+func (ps *PolySynth) NoteOn(channel, key int, velocity float64) NoteID {
+	ps.NoteOff(channel, key)
+
+	id := ps.attack(getGlobalTime(), MIDIToFreq(key), velocity)
+
+	ps.m.Lock()
+	ps.keys[midiKey{channel, key}] = id
+	ps.m.Unlock()
+
+	return id
+}
+
+// NoteOff releases the note held by a MIDI channel and key number.
+func (ps *PolySynth) NoteOff(channel, key int) {
+	ps.m.Lock()
+	id, ok := ps.keys[midiKey{channel, key}]
+	delete(ps.keys, midiKey{channel, key})
+	ps.m.Unlock()
+
+	if ok {
+		ps.ReleaseNote(id)
+	}
+}
+
+// SetNoteFreq changes the frequency of a playing note, for example to apply pitch bend to a single note.
+func (ps *PolySynth) SetNoteFreq(id NoteID, freq float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	v, ok := ps.notes[id]
+	if !ok {
+		return
+	}
+
+	if ps.mono {
+		v.synth.setNoteFreq(id, freq)
+		return
+	}
+
+	v.freq = freq
+	if !v.pending {
+		v.synth.SetFreq(freq)
+	}
+}
+
+// SetNoteAftertouch sets the aftertouch of a playing note, in the range 0 to 1.
+func (ps *PolySynth) SetNoteAftertouch(id NoteID, value float64) {
+	if v, ok := ps.getVoice(id); ok {
+		v.synth.SetAftertouch(value)
+	}
+}
+
+// Route adds a modulation route from a source to a destination on every voice. Each voice gets its own copy of the
+// source, so envelopes used as sources are triggered separately for each note.
+func (ps *PolySynth) Route(source ModSource, dest string, depth float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.base.Route(source, dest, depth)
+
+	for _, v := range ps.voices {
+		copied, err := copystructure.Copy(ModRoute{Source: source})
+		if err != nil {
+			panic(err)
+		}
+
+		v.synth.Route(copied.(ModRoute).Source, dest, depth)
+	}
+}
+
+// Controls returns the MIDI controller values shared by every voice, which are used by modulation sources such as
+// the mod wheel.
+func (ps *PolySynth) Controls() *Controls {
+	return ps.base.Controls()
+}
+
+// SetAmp sets the amplitude of every voice.
+func (ps *PolySynth) SetAmp(amp float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.base.SetAmp(amp)
+
+	for _, v := range ps.voices {
+		v.synth.SetAmp(amp)
+	}
+}
+
+// SetRamp sets how long every voice takes to reach a new amplitude or frequency, in seconds.
+func (ps *PolySynth) SetRamp(time float64) {
+	ps.m.Lock()
+	defer ps.m.Unlock()
+
+	ps.base.SetRamp(time)
+
+	for _, v := range ps.voices {
+		v.synth.SetRamp(time)
+	}
+}
+
+// SetNoteAmp changes the amplitude of a playing note.
+func (ps *PolySynth) SetNoteAmp(id NoteID, amp float64) {
+	if v, ok := ps.getVoice(id); ok {
+		v.synth.SetAmp(amp)
+	}
+}
+
+// TriggerAttackRelease triggers the attack phase of an Synth's envelope, followed by the release phase after the
+// specified period of time. It doesn't block, the release is scheduled on the global scheduler.
+func (ps *PolySynth) TriggerAttackRelease(d time.Duration, freq []float64) []NoteID {
+	return ps.ScheduleAttackRelease(Now(), d, freq, 1)
+}
+
+// ScheduleAttack schedules notes to start at time `at` on the global clock, with a velocity in the range 0 to 1. The
+// IDs of the notes are returned straight away so that they can be used before the notes have started.
+func (ps *PolySynth) ScheduleAttack(at float64, freq []float64, velocity float64) []NoteID {
+	ids := ps.reserve(len(freq))
+	freq = append([]float64(nil), freq...)
+
+	Schedule(at, func(t float64) {
+		for i, f := range freq {
+			ps.attackID(t, ids[i], f, velocity)
+		}
+	})
+
+	return ids
+}
+
+// ScheduleRelease schedules the notes with the given IDs to be released at time `at` on the global clock.
+func (ps *PolySynth) ScheduleRelease(at float64, ids ...NoteID) {
+	ids = append([]NoteID(nil), ids...)
+
+	Schedule(at, func(t float64) {
+		ps.release(t, ids...)
+	})
+}
+
+// ScheduleAttackRelease schedules notes to start at time `at` on the global clock and be released after the
+// specified period of time.
+func (ps *PolySynth) ScheduleAttackRelease(at float64, d time.Duration, freq []float64, velocity float64) []NoteID {
+	ids := ps.ScheduleAttack(at, freq, velocity)
+	ps.ScheduleRelease(at+d.Seconds(), ids...)
+
+	return ids
+}
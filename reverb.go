@@ -0,0 +1,228 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+// MaxPreDelay is the longest pre-delay supported by a Reverb, in milliseconds.
+const MaxPreDelay = 500.0
+
+// The tunings of Jezar's Freeverb, in samples at 44.1kHz. The right channel's delays are a little longer so that the
+// two channels are uncorrelated, which makes the reverb sound wide.
+var (
+	freeverbCombs      = []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	freeverbAllpasses  = []int{556, 441, 341, 225}
+	freeverbSpread     = 23
+	freeverbInputGain  = 0.015
+	freeverbWetGain    = 3.0
+	freeverbRoomScale  = 0.28
+	freeverbRoomOffset = 0.7
+	freeverbDampScale  = 0.4
+)
+
+// comb is a feedback comb filter with a low-pass filter in its feedback loop, which makes high frequencies die away
+// faster like they do in a real room.
+type comb struct {
+	buf   []float64
+	pos   int
+	store float64
+}
+
+// process filters a single sample.
+func (c *comb) process(x, feedback, damp float64) float64 {
+	y := c.buf[c.pos]
+
+	c.store = y*(1-damp) + c.store*damp
+	c.buf[c.pos] = x + c.store*feedback
+	c.pos = (c.pos + 1) % len(c.buf)
+
+	return y
+}
+
+// allpass is a Schroeder all-pass filter, which smears the echoes from the combs without colouring them.
+type allpass struct {
+	buf []float64
+	pos int
+}
+
+// process filters a single sample.
+func (a *allpass) process(x float64) float64 {
+	delayed := a.buf[a.pos]
+
+	a.buf[a.pos] = x + delayed*0.5
+	a.pos = (a.pos + 1) % len(a.buf)
+
+	return delayed - x
+}
+
+// Reverb is an algorithmic reverb based on Freeverb, which adds the sound of a room to its input. It is usually put
+// on the output of a mixer:
+//
+//	synth.GlobalMixer.Output().Add(synth.NewReverb(nil, 0.8, 0.5, 0.3))
+//
+// It keeps track of the samples it has produced, so each sample should only be streamed once, using either Stream
+// or StreamStereo.
+type Reverb struct {
+	Input Streamer
+
+	m *sync.Mutex
+
+	roomSize float64
+	damping  float64
+	width    *Param
+	mix      *Param
+	preDelay float64
+
+	combs     [2][]*comb
+	allpasses [2][]*allpass
+	pre       [2]*delayLine
+}
+
+// NewReverb returns a new reverb around a streamer. The room size and damping are limited to the range 0 to 1, and
+// the mix is how much of the reverb is heard, from 0 to 1.
+func NewReverb(input Streamer, roomSize, damping, mix float64) *Reverb {
+	r := &Reverb{
+		Input: input,
+		m:     &sync.Mutex{},
+
+		roomSize: math.Max(0, math.Min(1, roomSize)),
+		damping:  math.Max(0, math.Min(1, damping)),
+		width:    NewParam(1),
+		mix:      NewParam(mix),
+	}
+
+	// The tunings are scaled so that the reverb sounds the same at other sample rates.
+	scale := float64(sr) / 44100
+
+	for channel := range r.combs {
+		spread := channel * freeverbSpread
+
+		for _, length := range freeverbCombs {
+			r.combs[channel] = append(r.combs[channel], &comb{buf: make([]float64, int(float64(length+spread)*scale))})
+		}
+
+		for _, length := range freeverbAllpasses {
+			r.allpasses[channel] = append(r.allpasses[channel], &allpass{buf: make([]float64, int(float64(length+spread)*scale))})
+		}
+
+		r.pre[channel] = newDelayLine(int(MaxPreDelay / 1000 * float64(sr)))
+	}
+
+	return r
+}
+
+// SetInput sets the streamer which the reverb processes.
+func (r *Reverb) SetInput(input Streamer) {
+	r.Input = input
+}
+
+// Stream returns the reverberated sample for a given point in time `t`.
+func (r *Reverb) Stream(t float64) float64 {
+	x := r.Input.Stream(t)
+
+	l, rr := r.process(t, x, x)
+	return (l + rr) / 2
+}
+
+// StreamStereo returns the reverberated left and right channels for a given point in time `t`.
+func (r *Reverb) StreamStereo(t float64) (l, rr float64) {
+	l, rr = StreamStereo(r.Input, t)
+	return r.process(t, l, rr)
+}
+
+// process adds reverb to a pair of samples at time `t`.
+func (r *Reverb) process(t, l, rr float64) (float64, float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	in := [2]float64{l, rr}
+	var wet [2]float64
+
+	feedback := r.roomSize*freeverbRoomScale + freeverbRoomOffset
+	damp := r.damping * freeverbDampScale
+
+	for channel := range in {
+		x := in[channel]
+
+		if r.preDelay > 0 {
+			x = r.pre[channel].read(r.preDelay / 1000 * float64(sr))
+			r.pre[channel].write(in[channel])
+		}
+
+		x *= freeverbInputGain
+
+		for _, c := range r.combs[channel] {
+			wet[channel] += c.process(x, feedback, damp)
+		}
+
+		for _, a := range r.allpasses[channel] {
+			wet[channel] = a.process(wet[channel])
+		}
+	}
+
+	// At full width each channel only hears its own reverb, at no width both hear the same mix.
+	width, mix := r.width.At(t), r.mix.At(t)
+	wet1 := freeverbWetGain * (width/2 + 0.5)
+	wet2 := freeverbWetGain * (1 - width) / 2
+
+	outL := wet[0]*wet1 + wet[1]*wet2
+	outR := wet[1]*wet1 + wet[0]*wet2
+
+	return l*(1-mix) + outL*mix, rr*(1-mix) + outR*mix
+}
+
+// SetRoomSize sets the size of the room in the range 0 to 1. Bigger rooms have longer tails.
+func (r *Reverb) SetRoomSize(size float64) {
+	r.m.Lock()
+	r.roomSize = math.Max(0, math.Min(1, size))
+	r.m.Unlock()
+}
+
+// SetDamping sets how quickly high frequencies die away, in the range 0 to 1.
+func (r *Reverb) SetDamping(damping float64) {
+	r.m.Lock()
+	r.damping = math.Max(0, math.Min(1, damping))
+	r.m.Unlock()
+}
+
+// SetWidth sets the stereo width of the reverb, where 0 is mono and 1 is fully wide.
+func (r *Reverb) SetWidth(width float64) {
+	r.width.Set(math.Max(0, math.Min(1, width)))
+}
+
+// SetMix sets how much of the reverb is heard, where 0 is only the input and 1 is only the reverb.
+func (r *Reverb) SetMix(mix float64) {
+	r.mix.Set(mix)
+}
+
+// SetPreDelay sets how long the reverb waits before it starts, in milliseconds, up to MaxPreDelay. A short pre-delay
+// keeps the start of each note clear.
+func (r *Reverb) SetPreDelay(ms float64) {
+	r.m.Lock()
+	r.preDelay = math.Max(0, math.Min(MaxPreDelay, ms))
+	r.m.Unlock()
+}
+
+// Reset clears the reverb's tail.
+func (r *Reverb) Reset() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for channel := range r.combs {
+		for _, c := range r.combs[channel] {
+			for i := range c.buf {
+				c.buf[i] = 0
+			}
+			c.store = 0
+		}
+
+		for _, a := range r.allpasses[channel] {
+			for i := range a.buf {
+				a.buf[i] = 0
+			}
+		}
+
+		r.pre[channel].reset()
+	}
+}
@@ -0,0 +1,103 @@
+package synth
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// Event is an action which is run by the audio goroutine at an exact sample time `t`.
+type Event func(t float64)
+
+// scheduledEvent is an event along with the time it should be run at.
+type scheduledEvent struct {
+	at    float64
+	seq   uint64
+	event Event
+}
+
+// eventQueue is a min-heap of events ordered by time. Events scheduled for the same time run in the order they were
+// scheduled.
+type eventQueue []scheduledEvent
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+
+	return q[i].at < q[j].at
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(scheduledEvent)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return e
+}
+
+// Scheduler queues events to be run by the audio goroutine at exact sample times, so that timing isn't affected by
+// the goroutine scheduler or the size of the output buffer.
+type Scheduler struct {
+	m     *sync.Mutex
+	queue eventQueue
+	seq   uint64
+}
+
+// NewScheduler returns a new, empty scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		m: &sync.Mutex{},
+	}
+}
+
+// Schedule queues an event to be run at time `at`, in seconds on the global clock. It doesn't block. Events
+// scheduled in the past are run on the next sample.
+func (s *Scheduler) Schedule(at float64, event Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.seq++
+	heap.Push(&s.queue, scheduledEvent{at: at, seq: s.seq, event: event})
+}
+
+// Process runs every event which is due at or before time `t`. It is called by the audio goroutine once per sample,
+// before the sample is streamed.
+func (s *Scheduler) Process(t float64) {
+	for {
+		s.m.Lock()
+		if len(s.queue) == 0 || s.queue[0].at > t {
+			s.m.Unlock()
+			return
+		}
+
+		e := heap.Pop(&s.queue).(scheduledEvent)
+		s.m.Unlock()
+
+		// The lock is released while the event runs so that events can schedule further events.
+		e.event(t)
+	}
+}
+
+// Clear removes every event which hasn't been run yet.
+func (s *Scheduler) Clear() {
+	s.m.Lock()
+	s.queue = nil
+	s.m.Unlock()
+}
+
+// Schedule queues an event to be run by the global scheduler at time `at`, in seconds on the global clock.
+func Schedule(at float64, event Event) {
+	GlobalScheduler.Schedule(at, event)
+}
+
+// Now returns the current time of the global clock in seconds. It can be used as a starting point for scheduling
+// events.
+func Now() float64 {
+	return getGlobalTime()
+}
@@ -2,7 +2,8 @@ package synth
 
 import (
 	"log"
-	"sync"
+	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/faiface/beep"
@@ -11,33 +12,41 @@ import (
 
 const sr = beep.SampleRate(44100)
 
-var t = 0.0
-var tmu = &sync.Mutex{}
+// clock is the current time of the audio goroutine in seconds, stored as the bits of a float64 so that it can be
+// read atomically.
+var clock uint64
 
 var (
-	// NoiseFunc is the global noise function.
+	// NoiseFunc is the global noise function. It returns the left and right channels which are sent to the audio
+	// output.
 	NoiseFunc = makeNoise
 
 	// GlobalMixer is the global mixer.
-	GlobalMixer = &Mixer{}
+	GlobalMixer = NewMixer()
+
+	// GlobalScheduler is the scheduler whose events are run by the audio goroutine.
+	GlobalScheduler = NewScheduler()
 )
 
-// sampleToPCM converts a float in the range -1 to +1 to two bytes of that sample encoded in PCM format.
-func sampleToPCM(val float64) (buf []byte) {
-	intVal := int16(val * (1<<15 - 1))
-	low := byte(intVal)
-	high := byte(intVal >> 8)
+// blockSize is the number of samples which the audio goroutine works out before writing them to the audio output
+// together.
+const blockSize = 256
 
-	return []byte{low, high}
+// sampleToPCM converts a float in the range -1 to +1 to a sample encoded in PCM format, and writes its two bytes to
+// the start of buf. Values outside the range are clamped rather than wrapping around.
+func sampleToPCM(buf []byte, val float64) {
+	intVal := int16(math.Round(clamp(val, 1) * (1<<15 - 1)))
+	buf[0] = byte(intVal)
+	buf[1] = byte(intVal >> 8)
 }
 
-func makeNoise(t float64) float64 {
-	return GlobalMixer.Stream(t)
+func makeNoise(t float64) (l, r float64) {
+	return GlobalMixer.StreamStereo(t)
 }
 
 // init initialises the audio handler and allows the playback of audio by the library's functions
 func init() {
-	context, err := oto.NewContext(int(sr), 1, 2, sr.N(time.Second/10))
+	context, err := oto.NewContext(int(sr), 2, 2, 2*2*sr.N(time.Second/10))
 	if err != nil {
 		log.Fatalf("could not create audio context: %v", err)
 	}
@@ -49,25 +58,30 @@ func init() {
 
 		log.Println("audio handler started")
 
-		tmu.Lock()
-		t = 0.0
-		tmu.Unlock()
+		// Each block holds two channels of two bytes per sample, and is reused so that nothing is allocated while
+		// playing.
+		buf := make([]byte, 2*2*blockSize)
+
+		// The time is calculated from the number of samples played rather than by repeatedly adding the length of
+		// a sample, so that it doesn't drift.
+		for n := 0; ; {
+			for i := 0; i < len(buf); i, n = i+4, n+1 {
+				t := float64(n) / float64(sr)
+				atomic.StoreUint64(&clock, math.Float64bits(t))
 
-		for {
-			val := NoiseFunc(t)
-			player.Write(sampleToPCM(val))
+				GlobalScheduler.Process(t)
 
-			tmu.Lock()
-			t += sr.D(1).Seconds()
-			tmu.Unlock()
+				l, r := output.process(NoiseFunc(t))
+				output.toPCM(buf[i:], l)
+				output.toPCM(buf[i+2:], r)
+			}
+
+			player.Write(buf)
 		}
 	}()
 }
 
-// getGlobalTime gets the value of t atomically.
+// getGlobalTime gets the current time of the audio goroutine atomically.
 func getGlobalTime() float64 {
-	tmu.Lock()
-	defer tmu.Unlock()
-
-	return t
+	return math.Float64frombits(atomic.LoadUint64(&clock))
 }
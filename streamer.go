@@ -1,41 +1,41 @@
 package synth
 
+import "math"
+
 // Streamer represents anything that produces audio at a given point.
 // The method Stream returns a value in the range -1 to 1 for a given time `t`.
 type Streamer interface {
 	Stream(t float64) float64
 }
 
-// StreamerFunc is a streamer made of a function.
-type StreamerFunc func(t float64) float64
-
-// Stream returns the corresponding samples from a streamer function.
-func (f StreamerFunc) Stream(t float64) float64 {
-	return f(t)
-}
-
-// Mixer is a streamer which combines the streams from lots of different streamers.
-type Mixer struct {
-	streamers []Streamer
+// StereoStreamer is a streamer which can also produce separate left and right channels.
+type StereoStreamer interface {
+	Streamer
+	StreamStereo(t float64) (l, r float64)
 }
 
-// Stream streams the combination of several streamers.
-func (m *Mixer) Stream(t float64) float64 {
-	sum := 0.0
-
-	for _, streamer := range m.streamers {
-		sum += streamer.Stream(t)
+// StreamStereo returns the left and right channels of a streamer at a given time `t`. Streamers which aren't
+// stereo are played equally in both channels.
+func StreamStereo(s Streamer, t float64) (l, r float64) {
+	if stereo, ok := s.(StereoStreamer); ok {
+		return stereo.StreamStereo(t)
 	}
 
-	return sum
+	val := s.Stream(t)
+	return val, val
 }
 
-// Add adds one or more streamers to the mixer.
-func (m *Mixer) Add(streamers ...Streamer) {
-	m.streamers = append(m.streamers, streamers...)
+// panGains returns the gain of the left and right channels for a pan position, where -1 is hard left and 1 is hard
+// right. It uses an equal-power pan law, scaled so that both channels have a gain of 1 in the centre.
+func panGains(pan float64) (l, r float64) {
+	angle := (math.Max(-1, math.Min(1, pan)) + 1) * math.Pi / 4
+	return math.Sqrt2 * math.Cos(angle), math.Sqrt2 * math.Sin(angle)
 }
 
-// NewMixer returns a new mixer for a set of streamers.
-func NewMixer(streamers ...Streamer) *Mixer {
-	return &Mixer{streamers: streamers}
+// StreamerFunc is a streamer made of a function.
+type StreamerFunc func(t float64) float64
+
+// Stream returns the corresponding samples from a streamer function.
+func (f StreamerFunc) Stream(t float64) float64 {
+	return f(t)
 }
@@ -1,192 +1,341 @@
 package synth
 
 import (
-	"fmt"
+	"math"
 	"sync"
 	"time"
-
-	"github.com/mitchellh/copystructure"
 )
 
 // Synth defines an Synth.
 type Synth struct {
-	streamFunc func(amp, freq, t float64) float64
+	streamFunc func(v *Voice, t float64) float64
 	Env        Envelope
 
-	m    *sync.Mutex
-	freq float64
-	amp  float64
+	// Pan is the position of the synth in the stereo field, where -1 is hard left and 1 is hard right.
+	Pan float64
+
+	// Mod is the list of modulation routes for the synth. Each voice of a PolySynth gets its own copy of the
+	// sources, so envelopes used as sources are triggered separately for each note.
+	Mod []ModRoute
+
+	// VelocityAmp controls how the velocity of a note affects its amplitude.
+	VelocityAmp VelocityTarget
+	// VelocityTime controls how the velocity of a note scales the durations of the envelope, if the envelope is a
+	// TimeScaler. A negative depth makes softer notes slower.
+	VelocityTime VelocityTarget
+
+	// Filter, if set, filters the output of the synth. Each voice of a PolySynth gets its own copy, so the cutoff
+	// can be swept separately for each note.
+	Filter VoiceFilter
+	// Cutoff is the base cutoff of the filter in hertz, before it is modulated by the filter envelope, key tracking
+	// and velocity. If it is 0, the filter's cutoff is left alone.
+	Cutoff float64
+	// FilterEnv, if set, is triggered along with the main envelope and raises the cutoff by FilterEnvDepth octaves
+	// at its peak. A negative depth sweeps the cutoff down instead.
+	FilterEnv      Envelope
+	FilterEnvDepth float64
+	// KeyTrack is how closely the cutoff follows the pitch of the note, relative to middle C. At 1 the cutoff moves
+	// up an octave for every octave the note moves up.
+	KeyTrack float64
+	// VelocityCutoff controls how the velocity of a note affects the cutoff.
+	VelocityCutoff VelocityTarget
+
+	// Mono controls how overlapping notes played with NoteOn and NoteOff are handled.
+	Mono MonoSettings
+
+	m        *sync.Mutex
+	freq     *Param
+	amp      *Param
+	velocity float64
+	voice    Voice
+	controls *Controls
+
+	glide  glide
+	notes  noteStack
+	lastID NoteID
+
+	// The stream function is given a time which advances at the rate of the current frequency relative to the
+	// frequency the note started at. This keeps the phase continuous when the frequency changes mid-note.
+	phase   float64
+	refFreq float64
+	lastT   float64
 
 	finished bool
 }
 
 // Stream returns the correct sample for a given point in time `t`.
 func (s *Synth) Stream(t float64) float64 {
+	out, _ := s.sample(t)
+	return out
+}
+
+// StreamStereo returns the correct sample for a given point in time `t`, panned into the left and right channels.
+func (s *Synth) StreamStereo(t float64) (l, r float64) {
+	out, pan := s.sample(t)
+	gl, gr := panGains(pan)
+
+	return out * gl, out * gr
+}
+
+// sample returns the output of the synth at time `t`, along with where it should be panned.
+func (s *Synth) sample(t float64) (out, pan float64) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	if s.Env.GetAmplitude(t) <= 0 && s.Env.Started() {
+	if s.Env.GetAmplitude(t) <= 0 && s.Env.Started() && s.Env.Finished() {
 		s.finished = true
 	}
 
-	return s.amp * s.streamFunc(s.Env.GetAmplitude(t), s.freq, t)
-}
+	v := &s.voice
+	v.Amp = s.Env.GetAmplitude(t)
+	v.controls = s.controls
+	v.modulate(s.Mod, t)
 
-// TriggerAttack triggers the attack phase of the Synth's envelope.
-func (s *Synth) TriggerAttack(freq float64) {
-	s.SetFreq(freq)
-	s.Env.Attack(getGlobalTime())
-}
+	freq := s.glide.freq(t, s.freq.At(t))
+	if pitch := v.Mod(DestPitch); pitch != 0 {
+		freq *= math.Pow(2, pitch/12)
+	}
 
-// TriggerRelease triggers the release phase of the Synth's envelope.
-func (s *Synth) TriggerRelease() {
-	s.Env.Release(getGlobalTime())
+	if freq > 0 {
+		v.Key = 69 + 12*math.Log2(freq/440)
+	}
+
+	if s.refFreq != 0 {
+		s.phase += (t - s.lastT) * freq / s.refFreq
+	} else {
+		s.refFreq = freq
+		s.phase = t
+	}
+	s.lastT = t
+
+	// Without a reference frequency, such as for unpitched noise, there is no phase to keep track of.
+	if s.refFreq == 0 {
+		v.Freq = freq
+		out = s.streamFunc(v, t)
+	} else {
+		v.Freq = s.refFreq
+		out = s.streamFunc(v, s.phase)
+	}
+
+	if s.Filter != nil {
+		if s.Cutoff > 0 {
+			s.Filter.SetCutoff(s.cutoff(t, freq) * math.Pow(2, v.Mod(DestCutoff)))
+		}
+
+		out = s.Filter.Process(out)
+	}
+
+	amp := s.amp.At(t) * s.VelocityAmp.Scale(s.velocity) * math.Max(0, 1+v.Mod(DestAmp))
+	pan = math.Max(-1, math.Min(1, s.Pan+v.Mod(DestPan)))
+
+	return amp * out, pan
 }
 
-// TriggerAttackRelease triggers the attack phase of an Synth's envelope, followed by the release phase after the
-// specified period of time.
-func (s *Synth) TriggerAttackRelease(freq float64, t time.Duration) {
-	s.TriggerAttack(freq)
-	time.Sleep(t)
-	s.TriggerRelease()
+// cutoff returns the modulated cutoff of the filter at time `t` for a note with frequency `freq`. It must be called
+// with the lock held.
+func (s *Synth) cutoff(t, freq float64) float64 {
+	octaves := 0.0
+
+	if s.FilterEnv != nil {
+		octaves += s.FilterEnvDepth * s.FilterEnv.GetAmplitude(t)
+	}
+
+	if s.KeyTrack != 0 && freq > 0 {
+		octaves += s.KeyTrack * math.Log2(freq/MIDIToFreq(60))
+	}
+
+	return s.Cutoff * math.Pow(2, octaves) * s.VelocityCutoff.Scale(s.velocity)
 }
 
-// SetFreq sets the frequency of the streamer.
-func (s *Synth) SetFreq(freq float64) {
-	s.m.Lock()
-	s.freq = freq
-	s.m.Unlock()
+// TriggerAttack triggers the attack phase of the Synth's envelope at full velocity.
+func (s *Synth) TriggerAttack(freq float64) {
+	s.TriggerAttackVelocity(freq, 1)
 }
 
-// SetAmp sets the amplitude of the streamer.
-func (s *Synth) SetAmp(amp float64) {
-	s.m.Lock()
-	s.amp = amp
-	s.m.Unlock()
+// TriggerAttackVelocity triggers the attack phase of the Synth's envelope with a velocity in the range 0 to 1.
+func (s *Synth) TriggerAttackVelocity(freq, velocity float64) {
+	s.attack(getGlobalTime(), freq, velocity)
 }
 
-// Finished returns true if the synth has finished playing the current tone.
-func (s *Synth) Finished() bool {
+// attack starts a new note at time `t`.
+func (s *Synth) attack(t, freq, velocity float64) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	return s.finished
+	s.attackLocked(t, freq, velocity)
 }
 
-// NewSynth returns a new synth struct with initialised values. The amp value is the maximum amp value.
-func NewSynth(streamFunc func(amp, freq, t float64) float64, env Envelope, amp float64) *Synth {
-	return &Synth{
-		streamFunc: streamFunc,
-		Env:        env,
-		amp:        amp,
+// attackLocked starts a new note at time `t`. It must be called with the lock held.
+func (s *Synth) attackLocked(t, freq, velocity float64) {
+	s.freq.Jump(freq)
+	s.velocity = velocity
+	s.finished = false
+
+	s.glide.jump(freq)
+	s.refFreq = freq
+	s.phase = t
+	s.lastT = t
+
+	s.voice.start(t, velocity)
+	for _, route := range s.Mod {
+		if source, ok := route.Source.(triggeredSource); ok {
+			source.Attack(t)
+		}
+	}
 
-		m: &sync.Mutex{},
+	if scaler, ok := s.Env.(TimeScaler); ok {
+		scaler.SetTimeScale(s.VelocityTime.Scale(velocity))
 	}
-}
 
-// PolySynth defines a synth capable of polyphony.
-type PolySynth struct {
-	base  *Synth
-	m     *sync.Mutex
-	notes map[float64]*note
+	s.Env.Attack(t)
+
+	if s.FilterEnv != nil {
+		if scaler, ok := s.FilterEnv.(TimeScaler); ok {
+			scaler.SetTimeScale(s.VelocityTime.Scale(velocity))
+		}
+
+		s.FilterEnv.Attack(t)
+	}
 }
 
-type note struct {
-	synth *Synth
+// TriggerRelease triggers the release phase of the Synth's envelope.
+func (s *Synth) TriggerRelease() {
+	s.release(getGlobalTime())
+}
 
-	on  float64
-	off float64
+// release releases the current note at time `t`.
+func (s *Synth) release(t float64) {
+	s.m.Lock()
+	s.releaseLocked(t)
+	s.m.Unlock()
 }
 
-// NewPolySynth takes an existing synth and makes it capable of multiple voices.
-func NewPolySynth(synth *Synth) *PolySynth {
-	return &PolySynth{
-		base:  synth,
-		m:     &sync.Mutex{},
-		notes: map[float64]*note{},
+// releaseLocked releases the current note at time `t`. It must be called with the lock held.
+func (s *Synth) releaseLocked(t float64) {
+	s.Env.Release(t)
+
+	if s.FilterEnv != nil {
+		s.FilterEnv.Release(t)
+	}
+
+	for _, route := range s.Mod {
+		if source, ok := route.Source.(triggeredSource); ok {
+			source.Release(t)
+		}
 	}
 }
 
-// Stream returns the correct sample for a given point in time `t`.
-func (ps *PolySynth) Stream(t float64) float64 {
-	sum := 0.0
+// level returns the current envelope level of the synth, which is used to judge how loud a note is.
+func (s *Synth) level(t float64) float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
 
-	ps.m.Lock()
-	defer ps.m.Unlock()
+	return s.amp.At(t) * s.VelocityAmp.Scale(s.velocity) * s.Env.GetAmplitude(t)
+}
 
-	for freq, note := range ps.notes {
-		synth := note.synth
-		val := synth.Stream(t)
+// TriggerAttackRelease triggers the attack phase of an Synth's envelope, followed by the release phase after the
+// specified period of time. It doesn't block, the release is scheduled on the global scheduler.
+func (s *Synth) TriggerAttackRelease(freq float64, d time.Duration) {
+	s.ScheduleAttackRelease(Now(), freq, 1, d)
+}
 
-		// TODO: remove from synth map if empty
-		if val == 0 && note.synth.Finished() && note.off > note.on {
-			delete(ps.notes, freq)
-		}
+// ScheduleAttack schedules the attack phase of the Synth's envelope for time `at` on the global clock.
+func (s *Synth) ScheduleAttack(at, freq, velocity float64) {
+	Schedule(at, func(t float64) {
+		s.attack(t, freq, velocity)
+	})
+}
 
-		sum += val
-		// fmt.Println(val)
-	}
+// ScheduleRelease schedules the release phase of the Synth's envelope for time `at` on the global clock.
+func (s *Synth) ScheduleRelease(at float64) {
+	Schedule(at, s.release)
+}
 
-	return sum
+// ScheduleAttackRelease schedules the attack phase of the Synth's envelope for time `at` on the global clock,
+// followed by the release phase after the specified period of time.
+func (s *Synth) ScheduleAttackRelease(at, freq, velocity float64, d time.Duration) {
+	s.ScheduleAttack(at, freq, velocity)
+	s.ScheduleRelease(at + d.Seconds())
 }
 
-// addSynth adds a synth to the internal synth map, copied from the base synth. It also returns a copy of the synth made.
-func (ps *PolySynth) addSynth(freq float64) *note {
-	copied, err := copystructure.Copy(ps.base)
-	if err != nil {
-		panic(err)
-	}
+// SetFreq sets the frequency of the streamer. It ramps to the new frequency rather than jumping, so it can be used
+// for pitch bends.
+func (s *Synth) SetFreq(freq float64) {
+	s.m.Lock()
+	s.freq.Set(freq)
+	s.glide.jump(freq)
+	s.m.Unlock()
+}
 
-	s := copied.(*Synth)
-	s.m = &sync.Mutex{}
-	s.streamFunc = ps.base.streamFunc
-	s.SetFreq(freq)
-	s.SetAmp(ps.base.amp)
+// SetAmp sets the amplitude of the streamer. It ramps to the new amplitude rather than jumping, so it can be changed
+// while a note is playing without clicks.
+func (s *Synth) SetAmp(amp float64) {
+	s.amp.Set(amp)
+}
 
-	ps.m.Lock()
-	defer ps.m.Unlock()
+// SetRamp sets how long the synth takes to reach a new amplitude or frequency set with SetAmp or SetFreq, in seconds.
+// The amplitude ramps linearly and the frequency ramps exponentially, so that it moves evenly through the pitches.
+func (s *Synth) SetRamp(time float64) {
+	s.amp.SetRamp(time, LinearRamp)
+	s.freq.SetRamp(time, ExponentialRamp)
+}
 
-	n := &note{synth: s}
+// Velocity returns the velocity of the note currently being played.
+func (s *Synth) Velocity() float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
 
-	ps.notes[freq] = n
-	fmt.Println(s)
-	return n
+	return s.velocity
 }
 
-// getSynth attempts to get a synth for a given frequency
-func (ps *PolySynth) getSynth(freq float64) (*Synth, bool) {
-	ps.m.Lock()
-	defer ps.m.Unlock()
+// Route adds a modulation route from a source to a destination.
+func (s *Synth) Route(source ModSource, dest string, depth float64) {
+	s.m.Lock()
+	s.Mod = append(s.Mod, ModRoute{Source: source, Dest: dest, Depth: depth})
+	s.m.Unlock()
+}
 
-	return ps.notes[freq].synth, ps.notes[freq] != nil
+// Controls returns the MIDI controller values used by the synth's modulation sources.
+func (s *Synth) Controls() *Controls {
+	return s.controls
 }
 
-// TriggerAttack triggers the attack phase of the Synth's envelope.
-func (ps *PolySynth) TriggerAttack(freq []float64) {
-	for _, f := range freq {
-		note := ps.addSynth(f)
-		note.synth.Env.Attack(getGlobalTime())
-		note.on = getGlobalTime()
-	}
+// SetAftertouch sets the aftertouch of the note currently playing, in the range 0 to 1.
+func (s *Synth) SetAftertouch(value float64) {
+	s.m.Lock()
+	s.voice.Aftertouch = value
+	s.m.Unlock()
 }
 
-// TriggerRelease triggers the release phase of the Synth's envelope.
-func (ps *PolySynth) TriggerRelease(freq []float64) {
-	for _, f := range freq {
-		for _, note := range ps.notes {
-			if note.synth.freq == f {
-				note.synth.Env.Release(getGlobalTime())
-				note.off = getGlobalTime()
-			}
+// Finished returns true if the synth has finished playing the current tone.
+func (s *Synth) Finished() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
 
-		}
-	}
+	return s.finished
 }
 
-// TriggerAttackRelease triggers the attack phase of an Synth's envelope, followed by the release phase after the
-// specified period of time.
-func (ps *PolySynth) TriggerAttackRelease(t time.Duration, freq []float64) {
-	ps.TriggerAttack(freq)
-	time.Sleep(t)
-	ps.TriggerRelease(freq)
+// NewSynth returns a new synth struct with initialised values. The amp value is the maximum amp value.
+func NewSynth(streamFunc func(amp, freq, t float64) float64, env Envelope, amp float64) *Synth {
+	return NewVoiceSynth(func(v *Voice, t float64) float64 {
+		return streamFunc(v.Amp, v.Freq, t)
+	}, env, amp)
+}
+
+// NewVoiceSynth returns a new synth whose stream function is given the whole voice, so that it can read the
+// velocity of the note and any modulation routed to custom destinations.
+func NewVoiceSynth(streamFunc func(v *Voice, t float64) float64, env Envelope, amp float64) *Synth {
+	return &Synth{
+		streamFunc: streamFunc,
+		Env:        env,
+		amp:        NewParam(amp),
+		freq:       NewParamRamp(0, DefaultRampTime, ExponentialRamp),
+		velocity:   1,
+		voice:      Voice{Velocity: 1},
+		controls:   &Controls{},
+
+		VelocityAmp: VelocityTarget{Curve: LinearVelocity, Depth: 1},
+
+		m: &sync.Mutex{},
+	}
 }
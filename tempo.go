@@ -0,0 +1,66 @@
+package synth
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Division is the length of a note as a fraction of a whole note, which lasts four beats. It is used to sync
+// things like LFOs and delays to a tempo.
+type Division float64
+
+const (
+	// Whole is a whole note, which lasts four beats.
+	Whole Division = 1
+	// Half is a half note.
+	Half Division = 1.0 / 2
+	// Quarter is a quarter note, which lasts one beat.
+	Quarter Division = 1.0 / 4
+	// Eighth is an eighth note.
+	Eighth Division = 1.0 / 8
+	// Sixteenth is a sixteenth note.
+	Sixteenth Division = 1.0 / 16
+	// ThirtySecond is a thirty-second note.
+	ThirtySecond Division = 1.0 / 32
+)
+
+// Dotted returns the length of a dotted note, which is one and a half times as long.
+func (d Division) Dotted() Division {
+	return d * 1.5
+}
+
+// Triplet returns the length of a triplet note, three of which fit into the time of two normal notes.
+func (d Division) Triplet() Division {
+	return d * 2 / 3
+}
+
+// Tempo is a tempo in beats per minute. It is safe to change from any goroutine.
+type Tempo struct {
+	bpm uint64
+}
+
+// NewTempo returns a new tempo.
+func NewTempo(bpm float64) *Tempo {
+	tempo := &Tempo{}
+	tempo.SetBPM(bpm)
+
+	return tempo
+}
+
+// SetBPM sets the tempo in beats per minute.
+func (tempo *Tempo) SetBPM(bpm float64) {
+	atomic.StoreUint64(&tempo.bpm, math.Float64bits(bpm))
+}
+
+// BPM returns the tempo in beats per minute.
+func (tempo *Tempo) BPM() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&tempo.bpm))
+}
+
+// Seconds returns how long a note of the given length lasts at this tempo, in seconds.
+func (tempo *Tempo) Seconds(d Division) float64 {
+	return float64(d) * 4 * 60 / tempo.BPM()
+}
+
+// GlobalTempo is the tempo that tempo-synced LFOs and effects follow.
+var GlobalTempo = NewTempo(120)
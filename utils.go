@@ -7,3 +7,18 @@ import "math"
 func W(freq float64) float64 {
 	return 2 * math.Pi * freq
 }
+
+// MIDIToFreq converts a MIDI note number (e.g. 69) to a frequency in hertz (e.g. 440), using equal temperament.
+func MIDIToFreq(note int) float64 {
+	return math.Pow(2, float64(note-69)/12.0) * 440
+}
+
+// DBToGain converts a level in decibels (e.g. -6) to a linear gain (e.g. 0.5).
+func DBToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// GainToDB converts a linear gain (e.g. 0.5) to a level in decibels (e.g. -6). A gain of 0 is negative infinity.
+func GainToDB(gain float64) float64 {
+	return 20 * math.Log10(math.Abs(gain))
+}
@@ -0,0 +1,62 @@
+package synth
+
+import "math"
+
+// VelocityCurve maps the velocity of a note, in the range 0 to 1, to a value in the range 0 to 1.
+type VelocityCurve func(velocity float64) float64
+
+// LinearVelocity is a velocity curve where the output is equal to the velocity.
+func LinearVelocity(velocity float64) float64 {
+	return velocity
+}
+
+// SoftVelocity is a velocity curve which makes quiet notes louder, so less force is needed to play loudly.
+func SoftVelocity(velocity float64) float64 {
+	return math.Sqrt(velocity)
+}
+
+// HardVelocity is a velocity curve which makes quiet notes quieter, so more force is needed to play loudly.
+func HardVelocity(velocity float64) float64 {
+	return velocity * velocity
+}
+
+// PowerVelocity returns a velocity curve which raises the velocity to the power `exp`. Exponents less than 1 give a
+// softer response and exponents greater than 1 give a harder response.
+func PowerVelocity(exp float64) VelocityCurve {
+	return func(velocity float64) float64 {
+		return math.Pow(velocity, exp)
+	}
+}
+
+// FixedVelocity returns a velocity curve which ignores the velocity and always outputs the same value.
+func FixedVelocity(value float64) VelocityCurve {
+	return func(velocity float64) float64 {
+		return value
+	}
+}
+
+// VelocityTarget describes how strongly the velocity of a note affects a parameter.
+// A depth of 0 means the parameter ignores the velocity and a depth of 1 means the parameter is scaled by the full
+// output of the curve. A negative depth inverts the response, so that softer notes scale the parameter up instead.
+type VelocityTarget struct {
+	Curve VelocityCurve
+	Depth float64
+}
+
+// Scale returns the amount a parameter should be multiplied by for a given velocity. If no curve is set, a linear
+// curve is used.
+func (vt VelocityTarget) Scale(velocity float64) float64 {
+	curve := vt.Curve
+	if curve == nil {
+		curve = LinearVelocity
+	}
+
+	velocity = math.Max(0, math.Min(1, velocity))
+
+	return math.Max(0, 1+vt.Depth*(curve(velocity)-1))
+}
+
+// MIDIVelocity converts a MIDI velocity value (0 to 127) into a velocity in the range 0 to 1.
+func MIDIVelocity(velocity int) float64 {
+	return math.Max(0, math.Min(1, float64(velocity)/127))
+}
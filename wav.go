@@ -0,0 +1,186 @@
+package synth
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// The WAV format tags understood by ReadWAV.
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// Sample is a piece of audio loaded into memory, with one slice of samples in the range -1 to 1 for each channel.
+type Sample struct {
+	SampleRate int
+	Channels   [][]float64
+}
+
+// Len returns the number of samples in each channel.
+func (s *Sample) Len() int {
+	if len(s.Channels) == 0 {
+		return 0
+	}
+
+	return len(s.Channels[0])
+}
+
+// Resample returns the sample at a different sample rate, using linear interpolation. If the rate is unchanged,
+// the channels are shared with the original.
+func (s *Sample) Resample(rate int) *Sample {
+	if rate == s.SampleRate || s.SampleRate == 0 {
+		return &Sample{SampleRate: rate, Channels: s.Channels}
+	}
+
+	ratio := float64(s.SampleRate) / float64(rate)
+	length := int(float64(s.Len()) / ratio)
+
+	out := &Sample{SampleRate: rate}
+	for _, channel := range s.Channels {
+		resampled := make([]float64, length)
+
+		for i := range resampled {
+			pos := float64(i) * ratio
+			j := int(pos)
+			frac := pos - float64(j)
+
+			a := channel[j]
+			b := a
+			if j+1 < len(channel) {
+				b = channel[j+1]
+			}
+
+			resampled[i] = a + (b-a)*frac
+		}
+
+		out.Channels = append(out.Channels, resampled)
+	}
+
+	return out
+}
+
+// LoadWAV loads a WAV file. 8, 16, 24 and 32-bit integer files and 32 and 64-bit floating point files are
+// supported.
+func LoadWAV(path string) (*Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open wav: %v", err)
+	}
+	defer f.Close()
+
+	return ReadWAV(f)
+}
+
+// ReadWAV reads a WAV file.
+func ReadWAV(r io.Reader) (*Sample, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read wav: %v", err)
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a wav file")
+	}
+
+	var (
+		format, channels, bitDepth int
+		rate                       int
+		samples                    []byte
+		haveFormat                 bool
+	)
+
+	// The file is made of chunks, each with a four letter ID and a length. Chunks which aren't needed are skipped.
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+
+		if size < 0 || pos+size > len(data) {
+			size = len(data) - pos
+		}
+		chunk := data[pos : pos+size]
+
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, fmt.Errorf("wav format chunk is too short")
+			}
+
+			format = int(binary.LittleEndian.Uint16(chunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			rate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			bitDepth = int(binary.LittleEndian.Uint16(chunk[14:16]))
+
+			// Extensible files keep the real format at the start of the sub-format GUID.
+			if format == wavFormatExtensible && len(chunk) >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:26]))
+			}
+
+			haveFormat = true
+		case "data":
+			samples = chunk
+		}
+
+		// Chunks are padded to an even length.
+		pos += size + size%2
+	}
+
+	if !haveFormat {
+		return nil, fmt.Errorf("wav file has no format chunk")
+	}
+
+	if channels < 1 {
+		return nil, fmt.Errorf("wav file has no channels")
+	}
+
+	decode, err := wavDecoder(format, bitDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	width := bitDepth / 8
+	frames := len(samples) / (width * channels)
+
+	s := &Sample{SampleRate: rate, Channels: make([][]float64, channels)}
+	for c := range s.Channels {
+		s.Channels[c] = make([]float64, frames)
+	}
+
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			offset := (i*channels + c) * width
+			s.Channels[c][i] = decode(samples[offset : offset+width])
+		}
+	}
+
+	return s, nil
+}
+
+// wavDecoder returns a function which decodes one sample of the given format and bit depth.
+func wavDecoder(format, bitDepth int) (func(b []byte) float64, error) {
+	switch {
+	case format == wavFormatPCM && bitDepth == 8:
+		// 8-bit files are unsigned.
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case format == wavFormatPCM && bitDepth == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, nil
+	case format == wavFormatPCM && bitDepth == 24:
+		return func(b []byte) float64 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float64(v) / (1 << 23)
+		}, nil
+	case format == wavFormatPCM && bitDepth == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, nil
+	case format == wavFormatFloat && bitDepth == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	case format == wavFormatFloat && bitDepth == 64:
+		return func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }, nil
+	}
+
+	return nil, fmt.Errorf("unsupported wav format %d with %d bits", format, bitDepth)
+}